@@ -0,0 +1,492 @@
+package fragbag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+)
+
+// This file defines a compact binary encoding of fragment libraries, which is
+// much faster to read than JSON. A binary fragment library starts with the
+// magic bytes in binaryMagic followed by a format version, the fingerprint of
+// the library, the full tag of the library and finally the library itself.
+// Every library is preceded by a single byte indicating whether it is encoded
+// in binary or as JSON. (Only libraries defined in this package have a binary
+// encoding. Other libraries are stored as JSON.)
+//
+// All numbers are stored in big-endian byte order. Strings and lists are
+// preceded by their length as an unsigned 32 bit integer.
+
+const (
+	binaryMagic   = "FBLB"
+	binaryVersion = 2
+)
+
+const (
+	encodingBinary byte = iota
+	encodingJson
+)
+
+// binaryLibrary is implemented by libraries with a binary encoding.
+type binaryLibrary interface {
+	encodeBinary(enc *binEncoder)
+	decodeBinary(dec *binDecoder)
+}
+
+// SaveBinary stores the given fragment library with the writer provided using
+// a compact binary encoding. Libraries saved this way can be read with Open.
+func SaveBinary(w io.Writer, lib Library) error {
+	enc := &binEncoder{w: bufio.NewWriter(w)}
+	enc.bytes([]byte(binaryMagic))
+	enc.uint16(binaryVersion)
+	enc.string(Fingerprint(lib))
+	enc.library(lib)
+	if enc.err != nil {
+		return enc.err
+	}
+	return enc.w.Flush()
+}
+
+// openBinary reads a library written by SaveBinary. The entire library is
+// read into memory first, so that lengths read from it can be checked
+// against the number of bytes left.
+func openBinary(r io.Reader) (Library, error) {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := &binDecoder{r: bytes.NewReader(bs)}
+	if magic := string(dec.bytes(len(binaryMagic))); magic != binaryMagic {
+		return nil, fmt.Errorf("Corrupt fragment library. Expected magic "+
+			"bytes '%s' but got '%s'.", binaryMagic, magic)
+	}
+	version := dec.uint16()
+	if dec.err == nil && version != binaryVersion {
+		return nil, fmt.Errorf("Unsupported binary fragment library version "+
+			"%d. Only version %d is supported.", version, binaryVersion)
+	}
+
+	fp := dec.string()
+	lib := dec.library()
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if err := checkFingerprint(lib, fp); err != nil {
+		return nil, err
+	}
+	return lib, nil
+}
+
+// binEncoder writes binary values. Once an error occurs, all subsequent
+// writes are ignored and the error is stored in err.
+type binEncoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (enc *binEncoder) write(v interface{}) {
+	if enc.err == nil {
+		enc.err = binary.Write(enc.w, binary.BigEndian, v)
+	}
+}
+
+func (enc *binEncoder) bytes(bs []byte) {
+	if enc.err == nil {
+		_, enc.err = enc.w.Write(bs)
+	}
+}
+
+func (enc *binEncoder) uint16(n uint16)   { enc.write(n) }
+func (enc *binEncoder) uint32(n int)      { enc.write(uint32(n)) }
+func (enc *binEncoder) float32(f float32) { enc.write(f) }
+func (enc *binEncoder) float64(f float64) { enc.write(f) }
+func (enc *binEncoder) prob(p seq.Prob)   { enc.write(float64(p)) }
+func (enc *binEncoder) coords(c structure.Coords) {
+	enc.write([3]float64{c.X, c.Y, c.Z})
+}
+
+func (enc *binEncoder) string(s string) {
+	enc.uint32(len(s))
+	enc.bytes([]byte(s))
+}
+
+func (enc *binEncoder) alphabet(alpha seq.Alphabet) {
+	enc.uint32(len(alpha))
+	for _, r := range alpha {
+		enc.bytes([]byte{byte(r)})
+	}
+}
+
+// eprobs writes emission probabilities for each residue in the alphabet
+// given. The alphabet itself is not written.
+func (enc *binEncoder) eprobs(alpha seq.Alphabet, ep seq.EProbs) {
+	for _, r := range alpha {
+		enc.prob(ep.Lookup(r))
+	}
+}
+
+// library writes the full tag of the library followed by the library.
+func (enc *binEncoder) library(lib Library) {
+	tags := fullTag(lib)
+	enc.uint32(len(tags))
+	for _, tag := range tags {
+		enc.string(tag)
+	}
+	enc.libraryData(lib)
+}
+
+// libraryData writes a library without its tag.
+func (enc *binEncoder) libraryData(lib Library) {
+	if blib, ok := lib.(binaryLibrary); ok {
+		enc.bytes([]byte{encodingBinary})
+		blib.encodeBinary(enc)
+		return
+	}
+
+	enc.bytes([]byte{encodingJson})
+	raw, err := json.Marshal(lib)
+	if err != nil {
+		if enc.err == nil {
+			enc.err = err
+		}
+		return
+	}
+	enc.uint32(len(raw))
+	enc.bytes(raw)
+}
+
+// binDecoder reads binary values. Once an error occurs, all subsequent reads
+// return zero values and the error is stored in err.
+type binDecoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (dec *binDecoder) read(v interface{}) {
+	if dec.err == nil {
+		dec.err = binary.Read(dec.r, binary.BigEndian, v)
+		if dec.err == io.EOF {
+			dec.err = io.ErrUnexpectedEOF
+		}
+	}
+}
+
+func (dec *binDecoder) bytes(n int) []byte {
+	if dec.err != nil {
+		return nil
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(dec.r, bs); err != nil {
+		dec.err = err
+		return nil
+	}
+	return bs
+}
+
+func (dec *binDecoder) byte() byte {
+	if bs := dec.bytes(1); bs != nil {
+		return bs[0]
+	}
+	return 0
+}
+
+func (dec *binDecoder) uint16() (n uint16) {
+	dec.read(&n)
+	return
+}
+
+func (dec *binDecoder) uint32() int {
+	var n uint32
+	dec.read(&n)
+	return int(n)
+}
+
+func (dec *binDecoder) float32() (f float32) {
+	dec.read(&f)
+	return
+}
+
+func (dec *binDecoder) float64() (f float64) {
+	dec.read(&f)
+	return
+}
+
+func (dec *binDecoder) prob() seq.Prob {
+	return seq.Prob(dec.float64())
+}
+
+func (dec *binDecoder) coords() structure.Coords {
+	var xyz [3]float64
+	dec.read(&xyz)
+	return structure.Coords{X: xyz[0], Y: xyz[1], Z: xyz[2]}
+}
+
+func (dec *binDecoder) string() string {
+	return string(dec.bytes(dec.length()))
+}
+
+func (dec *binDecoder) alphabet() seq.Alphabet {
+	bs := dec.bytes(dec.length())
+	alpha := make(seq.Alphabet, len(bs))
+	for i, b := range bs {
+		alpha[i] = seq.Residue(b)
+	}
+	return alpha
+}
+
+func (dec *binDecoder) eprobs(alpha seq.Alphabet) seq.EProbs {
+	ep := seq.NewEProbs(alpha)
+	for _, r := range alpha {
+		ep.Set(r, dec.prob())
+	}
+	return ep
+}
+
+// length reads the length of a string or a list. Since lengths are used to
+// allocate memory, lengths larger than the number of bytes left are treated
+// as corruption. (Every element of a string or list takes at least one
+// byte.)
+func (dec *binDecoder) length() int {
+	var n uint32
+	dec.read(&n)
+	if left := dec.r.Len(); dec.err == nil && uint64(n) > uint64(left) {
+		dec.fail("Corrupt fragment library. Length %d is larger than the "+
+			"%d bytes left.", n, left)
+		return 0
+	}
+	return int(n)
+}
+
+func (dec *binDecoder) fail(format string, v ...interface{}) {
+	if dec.err == nil {
+		dec.err = fmt.Errorf(format, v...)
+	}
+}
+
+// library reads the full tag of a library and uses it to decode the library.
+func (dec *binDecoder) library() Library {
+	tags := make([]string, dec.length())
+	for i := range tags {
+		tags[i] = dec.string()
+	}
+	if dec.err != nil {
+		return nil
+	}
+	if len(tags) == 0 {
+		dec.fail("Corrupt fragment library. No tags founds.")
+		return nil
+	}
+
+	empty, err := makeEmptySubLibrary(tags...)
+	if err != nil {
+		dec.fail("%s", err)
+		return nil
+	}
+	dec.libraryData(empty)
+	return empty
+}
+
+// libraryData decodes a library into the empty library given.
+func (dec *binDecoder) libraryData(empty Library) {
+	switch encoding := dec.byte(); {
+	case dec.err != nil:
+	case encoding == encodingBinary:
+		blib, ok := empty.(binaryLibrary)
+		if !ok {
+			dec.fail("Library with tag '%s' has no binary encoding.",
+				empty.Tag())
+			return
+		}
+		blib.decodeBinary(dec)
+	case encoding == encodingJson:
+		raw := dec.bytes(dec.length())
+		if dec.err != nil {
+			return
+		}
+		if err := json.Unmarshal(raw, &empty); err != nil {
+			dec.fail("%s", err)
+		}
+	default:
+		dec.fail("Corrupt fragment library. Unknown encoding %d.", encoding)
+	}
+}
+
+func (lib *structureAtoms) encodeBinary(enc *binEncoder) {
+	enc.string(lib.Ident)
+	enc.uint32(lib.FragSize)
+	enc.uint32(len(lib.Fragments))
+	for _, frag := range lib.Fragments {
+		for _, atom := range frag.FragAtoms {
+			enc.coords(atom)
+		}
+	}
+}
+
+func (lib *structureAtoms) decodeBinary(dec *binDecoder) {
+	lib.Ident = dec.string()
+	lib.FragSize = dec.length()
+	lib.Fragments = make([]structureAtomsFrag, dec.length())
+	for i := range lib.Fragments {
+		atoms := make([]structure.Coords, lib.FragSize)
+		for j := range atoms {
+			atoms[j] = dec.coords()
+		}
+		lib.Fragments[i] = structureAtomsFrag{i, atoms}
+		if dec.err != nil {
+			return
+		}
+	}
+}
+
+func (lib *sequenceProfile) encodeBinary(enc *binEncoder) {
+	enc.string(lib.Ident)
+	enc.uint32(lib.FragSize)
+	if lib.Null == nil {
+		enc.bytes([]byte{0})
+	} else {
+		enc.bytes([]byte{1})
+		enc.alphabet(lib.Null.Alphabet)
+		enc.eprobs(lib.Null.Alphabet, *lib.Null)
+	}
+	enc.float64(lib.QueryComposition)
+
+	enc.uint32(len(lib.Fragments))
+	for _, frag := range lib.Fragments {
+		enc.alphabet(frag.Alphabet)
+		for _, column := range frag.Emissions {
+			enc.eprobs(frag.Alphabet, column)
+		}
+	}
+}
+
+func (lib *sequenceProfile) decodeBinary(dec *binDecoder) {
+	lib.Ident = dec.string()
+	lib.FragSize = dec.length()
+	if dec.byte() == 1 {
+		alpha := dec.alphabet()
+		null := dec.eprobs(alpha)
+		lib.Null = &null
+	}
+	lib.QueryComposition = dec.float64()
+
+	lib.Fragments = make([]sequenceProfileFrag, dec.length())
+	for i := range lib.Fragments {
+		alpha := dec.alphabet()
+		if dec.err != nil {
+			return
+		}
+		prof := seq.NewProfileAlphabet(lib.FragSize, alpha)
+		for c := range prof.Emissions {
+			prof.Emissions[c] = dec.eprobs(alpha)
+		}
+		lib.Fragments[i] = sequenceProfileFrag{i, prof}
+	}
+}
+
+func (lib *sequenceHMM) encodeBinary(enc *binEncoder) {
+	enc.string(lib.Ident)
+	enc.uint32(lib.FragSize)
+	enc.uint32(int(lib.Scoring))
+
+	enc.uint32(len(lib.Fragments))
+	for _, frag := range lib.Fragments {
+		alpha := frag.Alphabet
+		enc.alphabet(alpha)
+		enc.eprobs(alpha, frag.Null)
+		for _, node := range frag.Nodes {
+			enc.bytes([]byte{byte(node.Residue)})
+			enc.uint32(node.NodeNum)
+			enc.eprobs(alpha, node.MatEmit)
+			enc.eprobs(alpha, node.InsEmit)
+
+			tp := node.Transitions
+			for _, p := range []seq.Prob{
+				tp.MM, tp.MI, tp.MD, tp.IM, tp.II, tp.DM, tp.DD,
+			} {
+				enc.prob(p)
+			}
+		}
+	}
+}
+
+func (lib *sequenceHMM) decodeBinary(dec *binDecoder) {
+	lib.Ident = dec.string()
+	lib.FragSize = dec.length()
+	lib.Scoring = HMMScoring(dec.uint32())
+	if err := lib.Scoring.check(); dec.err == nil && err != nil {
+		dec.fail("Corrupt fragment library. %s", err)
+	}
+
+	lib.Fragments = make([]sequenceHMMFrag, dec.length())
+	for i := range lib.Fragments {
+		alpha := dec.alphabet()
+		null := dec.eprobs(alpha)
+		if dec.err != nil {
+			return
+		}
+
+		nodes := make([]seq.HMMNode, lib.FragSize)
+		for j := range nodes {
+			nodes[j].Residue = seq.Residue(dec.byte())
+			nodes[j].NodeNum = dec.uint32()
+			nodes[j].MatEmit = dec.eprobs(alpha)
+			nodes[j].InsEmit = dec.eprobs(alpha)
+			nodes[j].Transitions = seq.TProbs{
+				MM: dec.prob(), MI: dec.prob(), MD: dec.prob(),
+				IM: dec.prob(), II: dec.prob(),
+				DM: dec.prob(), DD: dec.prob(),
+			}
+		}
+		lib.Fragments[i] = sequenceHMMFrag{i, seq.NewHMM(nodes, alpha, null)}
+	}
+}
+
+func (lib *weightedTfIdf) encodeBinary(enc *binEncoder) {
+	enc.uint32(len(lib.FragIDFs))
+	for _, idf := range lib.FragIDFs {
+		enc.float32(idf)
+	}
+	enc.libraryData(lib.Library)
+}
+
+func (lib *weightedTfIdf) decodeBinary(dec *binDecoder) {
+	lib.FragIDFs = make([]float32, dec.length())
+	for i := range lib.FragIDFs {
+		lib.FragIDFs[i] = dec.float32()
+	}
+	dec.libraryData(lib.Library)
+}
+
+func (lib *paired) encodeBinary(enc *binEncoder) {
+	enc.string(lib.ident)
+	enc.library(lib.structure)
+	enc.library(lib.sequence)
+}
+
+func (lib *paired) decodeBinary(dec *binDecoder) {
+	lib.ident = dec.string()
+	structLib, seqLib := dec.library(), dec.library()
+	if dec.err != nil {
+		return
+	}
+
+	var ok bool
+	if lib.structure, ok = structLib.(StructureLibrary); !ok {
+		dec.fail("Library '%s' in paired library '%s' is not a structure "+
+			"library.", structLib.Name(), lib.ident)
+	}
+	if lib.sequence, ok = seqLib.(SequenceLibrary); !ok {
+		dec.fail("Library '%s' in paired library '%s' is not a sequence "+
+			"library.", seqLib.Name(), lib.ident)
+	}
+	if err := checkUnweighted(lib.ident, structLib, seqLib); err != nil {
+		dec.fail("%s", err)
+	}
+}
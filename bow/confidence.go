@@ -0,0 +1,217 @@
+package bow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/structure"
+)
+
+// Confidence determines per-residue weights from confidence scores, like the
+// pLDDT scores that predicted structures store in the B-factor column of PDB
+// files.
+type Confidence struct {
+	// Residues with a score below the threshold have weight 0, so that no
+	// fragment containing them contributes to a BOW.
+	Threshold float64
+
+	// When positive, residues at or above the threshold are weighted by
+	// their score divided by Scale (e.g., 100 for pLDDT scores), up to a
+	// weight of 1. Otherwise, they have weight 1.
+	Scale float64
+}
+
+// Weight returns the weight of a residue with the score given.
+func (c Confidence) Weight(score float64) float64 {
+	switch {
+	case score < c.Threshold:
+		return 0
+	case c.Scale > 0 && score < c.Scale:
+		return score / c.Scale
+	}
+	return 1
+}
+
+// ReadBFactors reads the B-factors of the alpha-carbon atoms in the first
+// model of the PDB file given. They are keyed by chain identifier and then
+// by residue. (B-factors are read directly from ATOM records, since they
+// aren't kept by the pdb package. HETATM records are skipped, so that
+// calcium ions, whose atom name is also "CA", are never mistaken for
+// alpha-carbon atoms.)
+func ReadBFactors(r io.Reader) (map[byte]map[ResidueId]float64, error) {
+	bfactors := make(map[byte]map[ResidueId]float64)
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "ENDMDL") {
+			break
+		}
+		if !strings.HasPrefix(line, "ATOM  ") || len(line) < 66 ||
+			strings.TrimSpace(line[12:16]) != "CA" {
+			continue
+		}
+
+		num, err := strconv.Atoi(strings.TrimSpace(line[22:26]))
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: Invalid residue number '%s'.",
+				lineNum, line[22:26])
+		}
+		bfactor, err := strconv.ParseFloat(strings.TrimSpace(line[60:66]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: Invalid B-factor '%s'.",
+				lineNum, line[60:66])
+		}
+		id := ResidueId{Num: num}
+		if line[26] != ' ' {
+			id.Insertion = line[26]
+		}
+
+		chain := line[21]
+		if bfactors[chain] == nil {
+			bfactors[chain] = make(map[ResidueId]float64)
+		}
+		if _, ok := bfactors[chain][id]; !ok { // skip alternate locations
+			bfactors[chain][id] = bfactor
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return bfactors, nil
+}
+
+type confidentStructure struct {
+	pdbChainStructure
+	scores map[ResidueId]float64
+	conf   Confidence
+}
+
+// BowerFromConfidentChain provides a reference implementation of the
+// StructureBower interface for PDB chains with per-residue confidence
+// scores, like the B-factors of a chain in a predicted structure (see
+// ReadBFactors). Each residue of the first model of the chain is weighted
+// according to its score (see Confidence), and residues without a score have
+// weight 0. (See StructureBowWeights for how weights are used.)
+//
+// The coverage of the BOW is recorded in its meta data.
+func BowerFromConfidentChain(
+	c *pdb.Chain,
+	scores map[ResidueId]float64,
+	conf Confidence,
+) StructureBower {
+	return confidentStructure{pdbChainStructure{c}, scores, conf}
+}
+
+func (c confidentStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	var first *pdb.Model
+	var atoms []structure.Coords
+	var weights []float64
+	if len(c.Models) > 0 {
+		first = c.Models[0]
+		for _, r := range first.Residues {
+			for _, atom := range r.Atoms {
+				if atom.Name != "CA" {
+					continue
+				}
+				id := ResidueId{r.SequenceNum, r.InsertionCode}
+				if id.Insertion == ' ' {
+					id.Insertion = 0
+				}
+				score, ok := c.scores[id]
+				weight := 0.0
+				if ok {
+					weight = c.conf.Weight(score)
+				}
+				atoms = append(atoms, atom.Coords)
+				weights = append(weights, weight)
+				break
+			}
+		}
+	}
+
+	b, coverage := StructureBowWeights(lib, atoms, weights)
+	meta := pdbMetadata(c.Entry, c.Chain, first)
+	meta.Coverage = &coverage
+	return Bowed{
+		Id:          c.id(),
+		Meta:        meta,
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         b,
+	}
+}
+
+// StructureBowWeights is like StructureBow, except every alpha-carbon atom
+// has a weight between 0 and 1. Each fragment window contributes the
+// smallest weight of its atoms to the BOW (instead of 1), so that windows
+// with an atom of weight 0 are skipped entirely.
+//
+// The coverage of the BOW is also returned, which is the sum of the
+// contributions of every window divided by the number of windows. (It is 1
+// when every weight is 1.)
+func StructureBowWeights(
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+	weights []float64,
+) (Bow, float64) {
+	b, coverage, _ := StructureBowWeightsContext(context.Background(), lib,
+		atoms, weights, nil)
+	return b, coverage
+}
+
+// StructureBowWeightsContext is like StructureBowWeights, except it stops
+// and returns the context's error if the context is cancelled. Progress is
+// reported with the number of windows of atoms considered. The progress
+// function may be nil.
+func StructureBowWeightsContext(
+	ctx context.Context,
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+	weights []float64,
+	progress Progress,
+) (Bow, float64, error) {
+	if len(atoms) != len(weights) {
+		panic(fmt.Sprintf("Cannot compute BOW with %d weights for %d atoms.",
+			len(weights), len(atoms)))
+	}
+
+	b := NewBow(lib.Size())
+	fragSize := lib.FragmentSize()
+	uplimit := len(atoms) - fragSize
+	windows, total := 0, 0.0
+	for i := 0; i <= uplimit; i++ {
+		if err := ctx.Err(); err != nil {
+			return Bow{}, 0, err
+		}
+		progress.Report(i, uplimit+1)
+		windows++
+		weight := 1.0
+		for _, w := range weights[i : i+fragSize] {
+			if w < weight {
+				weight = w
+			}
+		}
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+
+		best := lib.BestStructureFragment(atoms[i : i+fragSize])
+		if best > -1 {
+			b.Freqs[best] += float32(weight)
+		}
+	}
+	progress.Report(windows, windows)
+	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
+		b = b.Weighted(wlib)
+	}
+	if windows == 0 {
+		return b, 0, nil
+	}
+	return b, total / float64(windows), nil
+}
@@ -0,0 +1,53 @@
+package bow
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBFactors(t *testing.T) {
+	pdbFile := strings.Join([]string{
+		"ATOM      1  N   MET A   1       1.000   2.000   3.000" +
+			"  1.00 80.10           N",
+		"ATOM      2  CA  MET A   1       1.000   2.000   3.000" +
+			"  1.00 87.50           C",
+		"ATOM      3  CA  GLY A   2A      1.000   2.000   3.000" +
+			"  1.00 45.25           C",
+		"HETATM    4 CA    CA A   3       1.000   2.000   3.000" +
+			"  1.00 99.00          CA",
+		"ATOM      5  CA  ALA B   7       1.000   2.000   3.000" +
+			"  1.00 60.00           C",
+		"ENDMDL",
+		"ATOM      6  CA  ALA B   8       1.000   2.000   3.000" +
+			"  1.00 70.00           C",
+	}, "\n")
+	bfactors, err := ReadBFactors(strings.NewReader(pdbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[byte]map[ResidueId]float64{
+		'A': {{1, 0}: 87.5, {2, 'A'}: 45.25},
+		'B': {{7, 0}: 60},
+	}
+	if !reflect.DeepEqual(bfactors, expected) {
+		t.Fatalf("Expected B-factors %v but got %v.", expected, bfactors)
+	}
+}
+
+func TestMetadataCoverage(t *testing.T) {
+	var meta Metadata
+	if _, ok := meta.Get("coverage"); ok || !meta.IsZero() {
+		t.Fatal("Expected meta data without a coverage to be empty.")
+	}
+
+	zero := 0.0
+	meta.Coverage = &zero
+	if v, ok := meta.Get("coverage"); !ok || v != "0" {
+		t.Fatalf("Expected coverage '0' but got '%s'.", v)
+	}
+	if meta.IsZero() {
+		t.Fatal("Expected meta data with a coverage of zero to be set.")
+	}
+}
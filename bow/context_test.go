@@ -0,0 +1,109 @@
+package bow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+)
+
+// cancelling returns a context along with a progress function that cancels
+// it on its first report.
+func cancelling() (context.Context, Progress) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return ctx, func(done, total int) { cancel() }
+}
+
+func TestStructureBowCancel(t *testing.T) {
+	frags := [][]structure.Coords{
+		{{0, 0, 0}, {3.8, 0, 0}, {7.6, 0, 0}},
+		{{0, 0, 0}, {3.8, 0, 0}, {3.8, 3.8, 0}},
+	}
+	lib, err := fragbag.NewStructureAtoms("test", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atoms := make([]structure.Coords, 10)
+	for i := range atoms {
+		atoms[i] = structure.Coords{3.8 * float64(i), 0, 0}
+	}
+	weights := make([]float64, len(atoms))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	tests := []struct {
+		name string
+		bow  func(context.Context, Progress) error
+	}{
+		{"StructureBowContext", func(ctx context.Context, p Progress) error {
+			_, err := StructureBowContext(ctx, lib, atoms, p)
+			return err
+		}},
+		{"StructureBowSegmentsContext",
+			func(ctx context.Context, p Progress) error {
+				segments := [][]structure.Coords{atoms[:5], atoms[5:]}
+				_, err := StructureBowSegmentsContext(ctx, lib, segments, p)
+				return err
+			}},
+		{"StructureBowWeightsContext",
+			func(ctx context.Context, p Progress) error {
+				_, _, err := StructureBowWeightsContext(ctx, lib, atoms,
+					weights, p)
+				return err
+			}},
+	}
+	for _, test := range tests {
+		if err := test.bow(context.Background(), nil); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		ctx, progress := cancelling()
+		if err := test.bow(ctx, progress); err != context.Canceled {
+			t.Fatalf("%s: Expected cancellation, but got %v.",
+				test.name, err)
+		}
+	}
+}
+
+func TestSequenceBowCancel(t *testing.T) {
+	alpha := seq.Alphabet("AB")
+	frag := seq.NewProfileAlphabet(2, alpha)
+	for c := range frag.Emissions {
+		frag.Emissions[c] = seq.NewEProbs(alpha)
+	}
+	lib, err := fragbag.NewSequenceProfile("test", []*seq.Profile{frag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := seq.NewSequenceString("query", "ABABABAB")
+	prof := seq.NewProfileAlphabet(s.Len(), alpha)
+	for c := range prof.Emissions {
+		prof.Emissions[c] = seq.NewEProbs(alpha)
+	}
+
+	tests := []struct {
+		name string
+		bow  func(context.Context, Progress) error
+	}{
+		{"SequenceBowContext", func(ctx context.Context, p Progress) error {
+			_, err := SequenceBowContext(ctx, lib, s, p)
+			return err
+		}},
+		{"ProfileBowContext", func(ctx context.Context, p Progress) error {
+			_, err := ProfileBowContext(ctx, lib, prof, p)
+			return err
+		}},
+	}
+	for _, test := range tests {
+		if err := test.bow(context.Background(), nil); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		ctx, progress := cancelling()
+		if err := test.bow(ctx, progress); err != context.Canceled {
+			t.Fatalf("%s: Expected cancellation, but got %v.",
+				test.name, err)
+		}
+	}
+}
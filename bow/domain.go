@@ -0,0 +1,463 @@
+package bow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/structure"
+)
+
+// Classification schemes of domains.
+const (
+	DomainScop = "scop"
+	DomainCath = "cath"
+	DomainEcod = "ecod"
+)
+
+// Domain is a structural domain of a PDB entry, which is made up of one or
+// more (possibly discontinuous) segments of residues.
+type Domain struct {
+	// The identifier of the domain, e.g., "d1ctfa_" (SCOP), "1ctfA01"
+	// (CATH) or "e1ctfA1" (ECOD).
+	Id string
+
+	// The lower case identifier of the PDB entry containing the domain.
+	Entry string
+
+	// The segments of the domain, in order.
+	Segments []Segment
+
+	// The classification scheme of the domain (DomainScop, DomainCath or
+	// DomainEcod) and the classification of the domain in that scheme,
+	// e.g., "a.1.1.1". The classification may be empty.
+	Scheme, Class string
+}
+
+// Segment is a contiguous range of residues in a chain, from Start to End
+// inclusive. If Whole is true, the segment is the entire chain and Start and
+// End are ignored. If Chain is 0, the segment applies to every protein chain
+// in the entry.
+type Segment struct {
+	Chain      byte
+	Start, End ResidueId
+	Whole      bool
+}
+
+// ResidueId identifies a residue in a chain by its sequence number and
+// insertion code. The insertion code is 0 if the residue has none.
+type ResidueId struct {
+	Num       int
+	Insertion byte
+}
+
+func (d Domain) segmentsString() string {
+	segs := make([]string, len(d.Segments))
+	for i, seg := range d.Segments {
+		segs[i] = seg.String()
+	}
+	return strings.Join(segs, ",")
+}
+
+// String returns the segment in the format used by SCOP, e.g., "A:1-100" or
+// "A:" for an entire chain.
+func (seg Segment) String() string {
+	chain := ""
+	if seg.Chain != 0 {
+		chain = string(seg.Chain) + ":"
+	}
+	switch {
+	case seg.Whole && seg.Chain == 0:
+		return "-"
+	case seg.Whole:
+		return chain
+	}
+	return fmt.Sprintf("%s%s-%s", chain, seg.Start, seg.End)
+}
+
+func (r ResidueId) String() string {
+	if r.Insertion == 0 {
+		return strconv.Itoa(r.Num)
+	}
+	return fmt.Sprintf("%d%c", r.Num, r.Insertion)
+}
+
+// residueId returns the id of the residue given.
+func residueId(res *pdb.Residue) ResidueId {
+	ins := res.InsertionCode
+	if ins == ' ' {
+		ins = 0
+	}
+	return ResidueId{res.SequenceNum, ins}
+}
+
+// less returns true if this id comes before the id given. Ids are ordered by
+// sequence number and then by insertion code, where a residue without an
+// insertion code comes before residues with the same number that have one.
+func (r ResidueId) less(r2 ResidueId) bool {
+	if r.Num != r2.Num {
+		return r.Num < r2.Num
+	}
+	return r.Insertion < r2.Insertion
+}
+
+// ReadScopDomains reads domain definitions from a SCOP (or SCOPe)
+// classification file, e.g., 'dir.cla.scope.2.07-stable.txt'. Lines
+// starting with '#' are skipped.
+func ReadScopDomains(r io.Reader) ([]Domain, error) {
+	return readDomains(r, false, func(fields []string) (Domain, error) {
+		if len(fields) < 4 {
+			return Domain{}, fmt.Errorf("Expected at least 4 fields.")
+		}
+		segs, err := parseSegments(fields[2], true)
+		return Domain{
+			Id:       fields[0],
+			Entry:    strings.ToLower(fields[1]),
+			Segments: segs,
+			Scheme:   DomainScop,
+			Class:    fields[3],
+		}, err
+	})
+}
+
+// ReadCathDomains reads domain definitions from a CATH file in the 'cath-b'
+// format, e.g., 'cath-b-newest-all'. Each line has a domain id, a version,
+// a classification and the segments of the domain (e.g., "1-48:A,140-155:A").
+// Lines starting with '#' are skipped.
+func ReadCathDomains(r io.Reader) ([]Domain, error) {
+	return readDomains(r, false, func(fields []string) (Domain, error) {
+		if len(fields) < 4 {
+			return Domain{}, fmt.Errorf("Expected at least 4 fields.")
+		}
+		if len(fields[0]) < 4 {
+			return Domain{}, fmt.Errorf("Invalid CATH domain id '%s'.",
+				fields[0])
+		}
+		segs, err := parseSegments(fields[3], false)
+		return Domain{
+			Id:       fields[0],
+			Entry:    strings.ToLower(fields[0][0:4]),
+			Segments: segs,
+			Scheme:   DomainCath,
+			Class:    fields[2],
+		}, err
+	})
+}
+
+// ReadEcodDomains reads domain definitions from an ECOD 'domains.txt' file.
+// The domain id, F-group id, PDB id and PDB residue range columns are used.
+// Lines starting with '#' are skipped.
+func ReadEcodDomains(r io.Reader) ([]Domain, error) {
+	return readDomains(r, true, func(fields []string) (Domain, error) {
+		if len(fields) < 7 {
+			return Domain{}, fmt.Errorf("Expected at least 7 fields.")
+		}
+		segs, err := parseSegments(fields[6], true)
+		return Domain{
+			Id:       fields[1],
+			Entry:    strings.ToLower(fields[4]),
+			Segments: segs,
+			Scheme:   DomainEcod,
+			Class:    fields[3],
+		}, err
+	})
+}
+
+// readDomains calls 'parse' with the fields of every line in the reader
+// given that isn't empty or a comment. Fields are separated by tabs if 'tabs'
+// is true, and by any white space otherwise.
+func readDomains(
+	r io.Reader,
+	tabs bool,
+	parse func(fields []string) (Domain, error),
+) ([]Domain, error) {
+	var domains []Domain
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if len(strings.TrimSpace(line)) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var fields []string
+		if tabs {
+			fields = strings.Split(line, "\t")
+		} else {
+			fields = strings.Fields(line)
+		}
+		d, err := parse(fields)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", lineNum, err)
+		}
+		domains = append(domains, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
+
+// parseSegments parses a comma separated list of segments. The chain of each
+// segment is given before its range (e.g., "A:1-100") if 'chainFirst' is
+// true, and after its range (e.g., "1-100:A") otherwise. A segment without a
+// range (e.g., "A:") is an entire chain, a segment without a chain (e.g.,
+// "1-100") applies to every chain and "-" is the entire entry.
+func parseSegments(s string, chainFirst bool) ([]Segment, error) {
+	if s == "-" {
+		return []Segment{{Whole: true}}, nil
+	}
+
+	var segs []Segment
+	for _, part := range strings.Split(s, ",") {
+		var seg Segment
+		chain, rng := "", part
+		if i := strings.IndexByte(part, ':'); i > -1 {
+			if chainFirst {
+				chain, rng = part[:i], part[i+1:]
+			} else {
+				chain, rng = part[i+1:], part[:i]
+			}
+		}
+		switch len(chain) {
+		case 0:
+		case 1:
+			seg.Chain = chain[0]
+		default:
+			return nil, fmt.Errorf("Invalid chain '%s' in segment '%s'.",
+				chain, part)
+		}
+
+		if len(rng) == 0 {
+			if seg.Chain == 0 {
+				return nil, fmt.Errorf("Invalid segment '%s'.", part)
+			}
+			seg.Whole = true
+			segs = append(segs, seg)
+			continue
+		}
+
+		// The start may be negative, so look for the separator after its
+		// first character.
+		i := strings.IndexByte(rng[1:], '-') + 1
+		if i == 0 {
+			return nil, fmt.Errorf("Invalid range in segment '%s'.", part)
+		}
+		var err error
+		if seg.Start, err = parseResidueId(rng[:i]); err != nil {
+			return nil, err
+		}
+		if seg.End, err = parseResidueId(rng[i+1:]); err != nil {
+			return nil, err
+		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// parseResidueId parses a residue sequence number optionally followed by an
+// insertion code, e.g., "-5" or "52A".
+func parseResidueId(s string) (ResidueId, error) {
+	var r ResidueId
+	if n := len(s); n > 0 && (s[n-1] < '0' || s[n-1] > '9') {
+		r.Insertion, s = s[n-1], s[:n-1]
+	}
+	num, err := strconv.Atoi(s)
+	if err != nil {
+		return r, fmt.Errorf("Invalid residue number '%s'.", s)
+	}
+	r.Num = num
+	return r, nil
+}
+
+type domainStructure struct {
+	entry  *pdb.Entry
+	domain Domain
+
+	// The first model of every chain in the domain, in order.
+	models []*pdb.Model
+
+	// The residues of every segment of the domain.
+	segments [][]*pdb.Residue
+}
+
+// BowerFromDomain provides a reference implementation of the StructureBower
+// interface for domains of PDB entries. Only the first model of each chain
+// is used, and fragments never span two segments of the domain. (See
+// StructureBowSegments.)
+//
+// An error is returned if the domain belongs to a different PDB entry, or if
+// a segment of the domain refers to a chain that is not in the entry, or to
+// a range without any residues in the entry.
+func BowerFromDomain(e *pdb.Entry, d Domain) (StructureBower, error) {
+	if !strings.EqualFold(d.Entry, e.IdCode) {
+		return nil, fmt.Errorf("The domain '%s' belongs to PDB entry '%s', "+
+			"not '%s'.", d.Id, d.Entry, e.IdCode)
+	}
+	ds := domainStructure{entry: e, domain: d}
+	addModel := func(chain *pdb.Chain) (*pdb.Model, error) {
+		if len(chain.Models) == 0 {
+			return nil, fmt.Errorf("Chain %c of '%s' has no models.",
+				chain.Ident, d.Id)
+		}
+		m := chain.Models[0]
+		for _, m2 := range ds.models {
+			if m2 == m {
+				return m, nil
+			}
+		}
+		ds.models = append(ds.models, m)
+		return m, nil
+	}
+
+	for _, seg := range d.Segments {
+		var chains []*pdb.Chain
+		if seg.Chain == 0 {
+			for _, chain := range e.Chains {
+				if chain.IsProtein() {
+					chains = append(chains, chain)
+				}
+			}
+		} else if chain := e.Chain(seg.Chain); chain != nil {
+			chains = append(chains, chain)
+		} else {
+			return nil, fmt.Errorf("Chain %c of '%s' could not be found.",
+				seg.Chain, d.Id)
+		}
+
+		for _, chain := range chains {
+			m, err := addModel(chain)
+			if err != nil {
+				return nil, err
+			}
+			residues, err := segmentResidues(m, seg)
+			if err != nil {
+				return nil, fmt.Errorf("Could not find segment %s of '%s': %s",
+					seg, d.Id, err)
+			}
+			ds.segments = append(ds.segments, residues)
+		}
+	}
+	if len(ds.segments) == 0 {
+		return nil, fmt.Errorf("The domain '%s' has no segments.", d.Id)
+	}
+	return ds, nil
+}
+
+// segmentResidues returns the residues of the model given in the segment
+// given. Residues are selected by their position in the model: from the
+// first residue whose id is the start of the segment to the last residue
+// whose id is the end of the segment. (Ids are not always in order, e.g.,
+// with insertion codes.)
+//
+// The residues at the boundaries of the segment need not be in the model,
+// since residues at the ends of chains often are not. If the start is
+// missing, the segment starts at the first residue whose id comes after it.
+// If the end is missing, the segment ends at the last residue whose id comes
+// before it.
+func segmentResidues(m *pdb.Model, seg Segment) ([]*pdb.Residue, error) {
+	if seg.Whole {
+		return m.Residues, nil
+	}
+	start, end := -1, -1
+	for i, r := range m.Residues {
+		id := residueId(r)
+		if start == -1 && id == seg.Start {
+			start = i
+		}
+		if id == seg.End {
+			end = i
+		}
+	}
+	if start == -1 {
+		for i, r := range m.Residues {
+			if !residueId(r).less(seg.Start) {
+				start = i
+				break
+			}
+		}
+	}
+	if end == -1 {
+		for i := len(m.Residues) - 1; i >= 0; i-- {
+			if !seg.End.less(residueId(m.Residues[i])) {
+				end = i
+				break
+			}
+		}
+	}
+	if start == -1 || end == -1 || end < start {
+		return nil, fmt.Errorf("No residues found from %s to %s.",
+			seg.Start, seg.End)
+	}
+	return m.Residues[start : end+1], nil
+}
+
+func (ds domainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	segments := make([][]structure.Coords, len(ds.segments))
+	for i, residues := range ds.segments {
+		segments[i] = caAtoms(residues)
+	}
+	return Bowed{
+		Id:          ds.domain.Id,
+		Meta:        ds.metadata(),
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         StructureBowSegments(lib, segments),
+	}
+}
+
+func (ds domainStructure) metadata() Metadata {
+	first, last := ds.segments[0], ds.segments[len(ds.segments)-1]
+	meta := Metadata{
+		Source: ds.entry.Path,
+		Entry:  strings.ToLower(ds.entry.IdCode),
+		Model:  ds.models[0].Num,
+		Extra:  map[string]string{"segments": ds.domain.segmentsString()},
+	}
+
+	var chains, sequence []byte
+	for _, m := range ds.models {
+		chains = append(chains, m.Chain.Ident)
+	}
+	for _, residues := range ds.segments {
+		for _, r := range residues {
+			sequence = append(sequence, byte(r.Name))
+		}
+	}
+	meta.Chain, meta.Sequence = string(chains), string(sequence)
+
+	// A range of residues only makes sense within a single chain.
+	if len(ds.models) == 1 && len(first) > 0 && len(last) > 0 {
+		meta.Start = first[0].SequenceNum
+		meta.End = last[len(last)-1].SequenceNum
+	}
+
+	switch ds.domain.Scheme {
+	case DomainScop:
+		meta.Scop = ds.domain.Class
+	case DomainCath:
+		meta.Cath = ds.domain.Class
+	default:
+		if len(ds.domain.Scheme) > 0 {
+			meta.Extra[ds.domain.Scheme] = ds.domain.Class
+		}
+	}
+	return meta
+}
+
+// caAtoms returns the coordinates of the alpha-carbon atoms of the residues
+// given. Residues without an alpha-carbon atom are skipped.
+func caAtoms(residues []*pdb.Residue) []structure.Coords {
+	atoms := make([]structure.Coords, 0, len(residues))
+	for _, r := range residues {
+		for _, atom := range r.Atoms {
+			if atom.Name == "CA" {
+				atoms = append(atoms, atom.Coords)
+				break
+			}
+		}
+	}
+	return atoms
+}
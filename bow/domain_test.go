@@ -0,0 +1,251 @@
+package bow
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/structure"
+)
+
+func TestReadDomains(t *testing.T) {
+	seg := func(chain byte, start, end string) Segment {
+		s, err := parseResidueId(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := parseResidueId(end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Segment{Chain: chain, Start: s, End: e}
+	}
+	tests := []struct {
+		name     string
+		read     func(io.Reader) ([]Domain, error)
+		line     string
+		expected Domain
+	}{
+		{
+			"scop whole chain",
+			ReadScopDomains,
+			"d1ctfa_\t1ctf\tA:\td.45.1.1\t38865\t" +
+				"cl=53931,cf=54735,sf=54736," +
+				"fa=54737,dm=54738,sp=54739,px=38865",
+			Domain{"d1ctfa_", "1ctf", []Segment{{Chain: 'A', Whole: true}},
+				DomainScop, "d.45.1.1"},
+		},
+		{
+			"scop whole entry",
+			ReadScopDomains,
+			"d1cpt__\t1cpt\t-\ta.104.1.1\t17204\t" +
+				"cl=46456,cf=48263,sf=48264," +
+				"fa=48265,dm=48266,sp=48267,px=17204",
+			Domain{"d1cpt__", "1cpt", []Segment{{Whole: true}},
+				DomainScop, "a.104.1.1"},
+		},
+		{
+			"scop multiple segments",
+			ReadScopDomains,
+			"d1cuka1\t1cuk\tA:1-63,A:157-203\tb.40.4.2\t25140\t" +
+				"cl=48724,cf=50198,sf=50249," +
+				"fa=50277,dm=50278,sp=50279,px=25140",
+			Domain{"d1cuka1", "1cuk",
+				[]Segment{seg('A', "1", "63"), seg('A', "157", "203")},
+				DomainScop, "b.40.4.2"},
+		},
+		{
+			"scop insertion codes",
+			ReadScopDomains,
+			"d1a0qh1\t1a0q\tH:1-113\tb.1.1.1\t20346\t" +
+				"cl=48724,cf=48725,sf=48726," +
+				"fa=48727,dm=48760,sp=48761,px=20346\n" +
+				"d7hvpb1\t7hvp\tL:1B-107A\tb.1.1.1\t20347\t" +
+				"cl=48724,cf=48725,sf=48726," +
+				"fa=48727,dm=48760,sp=48761,px=20347",
+			Domain{"d7hvpb1", "7hvp", []Segment{seg('L', "1B", "107A")},
+				DomainScop, "b.1.1.1"},
+		},
+		{
+			"cath single segment",
+			ReadCathDomains,
+			"101mA00 v4_2_0 1.10.490.10 0-153:A",
+			Domain{"101mA00", "101m", []Segment{seg('A', "0", "153")},
+				DomainCath, "1.10.490.10"},
+		},
+		{
+			"cath multiple segments",
+			ReadCathDomains,
+			"1cukA01 v4_2_0 2.40.50.140 1-48:A,140-155:A",
+			Domain{"1cukA01", "1cuk",
+				[]Segment{seg('A', "1", "48"), seg('A', "140", "155")},
+				DomainCath, "2.40.50.140"},
+		},
+		{
+			"cath insertion codes and negative numbers",
+			ReadCathDomains,
+			"1a0hB01 v4_2_0 2.40.10.10 -3-14L:B,16B-28:B",
+			Domain{"1a0hB01", "1a0h",
+				[]Segment{seg('B', "-3", "14L"), seg('B', "16B", "28")},
+				DomainCath, "2.40.10.10"},
+		},
+		{
+			"ecod multiple chains",
+			ReadEcodDomains,
+			"#uid\tecod_domain_id\tmanual_rep\tf_id\tpdb\tchain\t" +
+				"pdb_range\tseqid_range\tunp_acc\tarch_name\tx_name\t" +
+				"h_name\tt_name\tf_name\tasm_status\tligand\n" +
+				"000000267\te1htr.1\tAUTO_NONREP\t1.1.1.1\t1htr\t.\t" +
+				"P:1-43,B:1-146\tP:1-43,B:1-146\tP03954\talpha arrays\t" +
+				"HTH\tHTH\tHTH\tPepsin-like\tNOT_DOMAIN_ASSEMBLY\tNO_LIGANDS",
+			Domain{"e1htr.1", "1htr",
+				[]Segment{seg('P', "1", "43"), seg('B', "1", "146")},
+				DomainEcod, "1.1.1.1"},
+		},
+		{
+			"ecod insertion codes",
+			ReadEcodDomains,
+			"001502758\te1a0qH1\tAUTO_NONREP\t11.1.1.1\t1a0q\tH\t" +
+				"H:1-100C\tH:1-103\tNO_UNP\tbeta sandwiches\t" +
+				"Immunoglobulin-like beta-sandwich\tImmunoglobulin-like " +
+				"beta-sandwich\tImmunoglobulin\tV-set\t" +
+				"NOT_DOMAIN_ASSEMBLY\tNO_LIGANDS",
+			Domain{"e1a0qH1", "1a0q", []Segment{seg('H', "1", "100C")},
+				DomainEcod, "11.1.1.1"},
+		},
+	}
+	for _, test := range tests {
+		domains, err := test.read(strings.NewReader(test.line))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		got := domains[len(domains)-1]
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("%s: Expected %+v but got %+v.",
+				test.name, test.expected, got)
+		}
+	}
+}
+
+func TestReadDomainsInvalid(t *testing.T) {
+	lines := []string{
+		"d1ctfa_\t1ctf\tAB:1-10\td.45.1.1",
+		"d1ctfa_\t1ctf\tA:1\td.45.1.1",
+		"d1ctfa_\t1ctf\tA:x-10\td.45.1.1",
+		"d1ctfa_\t1ctf\tA:1-10",
+	}
+	for _, line := range lines {
+		if _, err := ReadScopDomains(strings.NewReader(line)); err == nil {
+			t.Fatalf("Expected an error for '%s'.", line)
+		}
+	}
+}
+
+// testModel returns a model with a residue for every id given, in order.
+func testModel(ids []ResidueId) *pdb.Model {
+	m := &pdb.Model{Num: 1}
+	for _, id := range ids {
+		m.Residues = append(m.Residues, &pdb.Residue{
+			Name:          'A',
+			SequenceNum:   id.Num,
+			InsertionCode: ' ',
+			Atoms: []pdb.Atom{{
+				Name:   "CA",
+				Coords: structure.Coords{3.8 * float64(id.Num), 0, 0},
+			}},
+		})
+		if id.Insertion != 0 {
+			m.Residues[len(m.Residues)-1].InsertionCode = id.Insertion
+		}
+	}
+	return m
+}
+
+func TestSegmentResidues(t *testing.T) {
+	// Residues 1 and 2 are missing, and residue 52 has two insertions.
+	ids := []ResidueId{
+		{3, 0}, {4, 0}, {51, 0}, {52, 0}, {52, 'A'}, {52, 'B'}, {53, 0},
+		{54, 0},
+	}
+	// Residue 5A is out of order, and residues 10 and 13 appear twice.
+	unordered := []ResidueId{
+		{10, 0}, {5, 'A'}, {11, 0}, {12, 0}, {10, 0}, {13, 0}, {13, 0},
+	}
+	m, um := testModel(ids), testModel(unordered)
+
+	tests := []struct {
+		m        *pdb.Model
+		seg      Segment
+		expected []ResidueId
+	}{
+		{m, Segment{Whole: true}, ids},
+		{m, Segment{Start: ResidueId{1, 0}, End: ResidueId{4, 0}}, ids[0:2]},
+		{m, Segment{Start: ResidueId{52, 'A'}, End: ResidueId{53, 0}},
+			ids[4:7]},
+		{m, Segment{Start: ResidueId{52, 0}, End: ResidueId{52, 'A'}},
+			ids[3:5]},
+		{m, Segment{Start: ResidueId{51, 0}, End: ResidueId{100, 0}},
+			ids[2:]},
+
+		// Residues are selected by position, from the first residue
+		// matching the start to the last residue matching the end.
+		{um, Segment{Start: ResidueId{10, 0}, End: ResidueId{11, 0}},
+			unordered[0:3]},
+		{um, Segment{Start: ResidueId{5, 'A'}, End: ResidueId{13, 0}},
+			unordered[1:]},
+		{um, Segment{Start: ResidueId{10, 0}, End: ResidueId{10, 0}},
+			unordered[0:5]},
+
+		// Missing boundaries fall back to the ordering of ids.
+		{um, Segment{Start: ResidueId{9, 0}, End: ResidueId{12, 0}},
+			unordered[0:4]},
+		{um, Segment{Start: ResidueId{11, 0}, End: ResidueId{12, 'A'}},
+			unordered[2:5]},
+	}
+	for _, test := range tests {
+		residues, err := segmentResidues(test.m, test.seg)
+		if err != nil {
+			t.Fatalf("Segment %s: %s", test.seg, err)
+		}
+		got := make([]ResidueId, len(residues))
+		for i, r := range residues {
+			got[i] = residueId(r)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("Segment %s: Expected residues %v but got %v.",
+				test.seg, test.expected, got)
+		}
+	}
+
+	empty := []Segment{
+		{Start: ResidueId{5, 0}, End: ResidueId{50, 0}},
+		{Start: ResidueId{53, 0}, End: ResidueId{52, 'B'}},
+		{Start: ResidueId{100, 0}, End: ResidueId{200, 0}},
+	}
+	for _, seg := range empty {
+		if _, err := segmentResidues(m, seg); err == nil {
+			t.Fatalf("Expected an error for segment %s.", seg)
+		}
+	}
+}
+
+func TestBowerFromDomainEntry(t *testing.T) {
+	e := &pdb.Entry{Path: "1ctf.pdb", IdCode: "1CTF"}
+	chain := &pdb.Chain{Entry: e, Ident: 'A'}
+	m := testModel([]ResidueId{{1, 0}, {2, 0}, {3, 0}})
+	m.Entry, m.Chain = e, chain
+	chain.Models = []*pdb.Model{m}
+	e.Chains = []*pdb.Chain{chain}
+
+	whole := []Segment{{Whole: true}}
+	d := Domain{"d1ctf__", "1ctf", whole, DomainScop, "d.45.1.1"}
+	if _, err := BowerFromDomain(e, d); err != nil {
+		t.Fatal(err)
+	}
+	d = Domain{"d1cuk__", "1cuk", whole, DomainScop, "b.40.4.2"}
+	if _, err := BowerFromDomain(e, d); err == nil {
+		t.Fatal("Expected an error for a domain of another PDB entry.")
+	}
+}
@@ -0,0 +1,112 @@
+package bow
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/seq"
+)
+
+// Metadata is structured meta data about the source of a bag-of-words. The
+// bowers in this package fill in whatever they know about their source, so
+// that it can be shown without reading the source again. Every field may be
+// empty.
+type Metadata struct {
+	// The path of the file that the source was read from.
+	Source string `json:",omitempty"`
+
+	// The PDB entry identifier (e.g., "1ctf") and chain identifier (e.g.,
+	// "A") of a structure.
+	Entry string `json:",omitempty"`
+	Chain string `json:",omitempty"`
+
+	// The model number of a structure.
+	Model int `json:",omitempty"`
+
+	// The sequence numbers of the first and last residues (inclusive) of a
+	// structure.
+	Start int `json:",omitempty"`
+	End   int `json:",omitempty"`
+
+	// The amino acid sequence of the source.
+	Sequence string `json:",omitempty"`
+
+	// The resolution of a structure in Angstroms (see ReadResolution). It
+	// is zero if the structure has no resolution, like NMR structures.
+	Resolution float64 `json:",omitempty"`
+
+	// SCOP and CATH labels of the source, like domain identifiers or
+	// classifications.
+	Scop string `json:",omitempty"`
+	Cath string `json:",omitempty"`
+
+	// The fraction of the source that contributed to the bag-of-words, for
+	// BOWs computed with per-residue weights (see StructureBowWeights). It
+	// is nil for BOWs computed without weights, so that it is distinct
+	// from a coverage of zero (when no part of the source was confident
+	// enough to contribute).
+	Coverage *float64 `json:",omitempty"`
+
+	// Arbitrary key/value pairs.
+	Extra map[string]string `json:",omitempty"`
+}
+
+// IsZero returns true if no meta data is set.
+func (m Metadata) IsZero() bool {
+	return m.Source == "" && m.Entry == "" && m.Chain == "" &&
+		m.Model == 0 && m.Start == 0 && m.End == 0 && m.Sequence == "" &&
+		m.Resolution == 0 && m.Scop == "" && m.Cath == "" &&
+		m.Coverage == nil && len(m.Extra) == 0
+}
+
+// Get returns the value of the field named by key as a string. Keys are
+// matched case insensitively against the names of fields (e.g., "chain" or
+// "resolution"), and then against the keys of Extra. The boolean returned
+// is false if there is no value for the key.
+func (m Metadata) Get(key string) (string, bool) {
+	itoa := func(n int) (string, bool) {
+		return strconv.Itoa(n), n != 0
+	}
+	str := func(s string) (string, bool) {
+		return s, len(s) > 0
+	}
+	switch strings.ToLower(key) {
+	case "source":
+		return str(m.Source)
+	case "entry":
+		return str(m.Entry)
+	case "chain":
+		return str(m.Chain)
+	case "model":
+		return itoa(m.Model)
+	case "start":
+		return itoa(m.Start)
+	case "end":
+		return itoa(m.End)
+	case "sequence":
+		return str(m.Sequence)
+	case "resolution":
+		return strconv.FormatFloat(m.Resolution, 'f', -1, 64),
+			m.Resolution != 0
+	case "coverage":
+		if m.Coverage == nil {
+			return "", false
+		}
+		return strconv.FormatFloat(*m.Coverage, 'f', -1, 64), true
+	case "scop":
+		return str(m.Scop)
+	case "cath":
+		return str(m.Cath)
+	}
+	v, ok := m.Extra[key]
+	return v, ok
+}
+
+// residues returns the residues given as a string.
+func residues(rs []seq.Residue) string {
+	bs := make([]byte, len(rs))
+	for i, r := range rs {
+		bs[i] = byte(r)
+	}
+	return string(bs)
+}
@@ -0,0 +1,38 @@
+package bow
+
+import (
+	"testing"
+)
+
+func TestMetadataIsZero(t *testing.T) {
+	if !(Metadata{}).IsZero() {
+		t.Fatal("Expected empty meta data to be zero.")
+	}
+	zero := 0.0
+	tests := []struct {
+		field string
+		meta  Metadata
+	}{
+		{"Source", Metadata{Source: "1ctf.pdb"}},
+		{"Entry", Metadata{Entry: "1ctf"}},
+		{"Chain", Metadata{Chain: "A"}},
+		{"Model", Metadata{Model: 1}},
+		{"Start", Metadata{Start: -3}},
+		{"End", Metadata{End: 68}},
+		{"Sequence", Metadata{Sequence: "MK"}},
+		{"Resolution", Metadata{Resolution: 1.9}},
+		{"Scop", Metadata{Scop: "d.45.1.1"}},
+		{"Cath", Metadata{Cath: "1.10.490.10"}},
+		{"Coverage", Metadata{Coverage: &zero}},
+		{"Extra", Metadata{Extra: map[string]string{"k": "v"}}},
+	}
+	for _, test := range tests {
+		if test.meta.IsZero() {
+			t.Fatalf("Expected meta data with %s set to not be zero.",
+				test.field)
+		}
+		if _, ok := test.meta.Get(test.field); test.field != "Extra" && !ok {
+			t.Fatalf("Expected a value for '%s'.", test.field)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package bow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/TuftsBCB/structure"
+)
+
+// ModelMode determines which models of each chain are used by PdbBowers and
+// CifBowers.
+type ModelMode int
+
+const (
+	// A bower for the first model of every chain.
+	FirstModel ModelMode = iota
+
+	// A bower for every model of every chain.
+	EveryModel
+
+	// A bower for every chain whose BOW is the average of the BOWs of all
+	// of its models. This is useful for NMR ensembles.
+	EnsembleModels
+)
+
+// PdbBowers returns a structure bower for every protein chain in the PDB
+// entry given, with the models of each chain chosen by the mode given.
+func PdbBowers(e *pdb.Entry, mode ModelMode) []StructureBower {
+	bowers := make([]StructureBower, 0, len(e.Chains))
+	for _, chain := range e.Chains {
+		if !chain.IsProtein() {
+			continue
+		}
+		switch mode {
+		case EveryModel:
+			for _, model := range chain.Models {
+				bowers = append(bowers, BowerFromModel(model))
+			}
+		case EnsembleModels:
+			bowers = append(bowers, BowerFromEnsemble(chain))
+		default:
+			bowers = append(bowers, BowerFromChain(chain))
+		}
+	}
+	return bowers
+}
+
+// CifBowers returns a structure bower for every chain with alpha-carbon
+// atoms in the mmCIF entry given, with the models of each chain chosen by
+// the mode given. The source given (usually the path of the file that the
+// entry was read from) is recorded in the meta data of every BOW, since
+// mmCIF entries do not record it. It may be empty.
+func CifBowers(e *pdbx.Entry, source string, mode ModelMode) []StructureBower {
+	var bowers []StructureBower
+	for _, entity := range e.Entities {
+		for _, chain := range entity.Chains {
+			if len(chain.Models) == 0 ||
+				len(chain.Models[0].AlphaCarbons) == 0 {
+				continue
+			}
+			c := cifChainStructure{chain, source}
+			switch mode {
+			case EveryModel:
+				for _, model := range chain.Models {
+					bowers = append(bowers, cifModelStructure{c, model})
+				}
+			case EnsembleModels:
+				bowers = append(bowers, cifEnsembleStructure{c})
+			default:
+				bowers = append(bowers, c)
+			}
+		}
+	}
+	return bowers
+}
+
+type pdbEnsembleStructure struct {
+	pdbChainStructure
+}
+
+// BowerFromEnsemble provides a reference implementation of the
+// StructureBower interface for every model of a PDB chain. Its BOW is the
+// average of the BOWs of every model of the chain. It has the same id as
+// the bower returned by BowerFromChain.
+func BowerFromEnsemble(c *pdb.Chain) StructureBower {
+	return pdbEnsembleStructure{pdbChainStructure{c}}
+}
+
+func (c pdbEnsembleStructure) StructureBow(
+	lib fragbag.StructureLibrary,
+) Bowed {
+	models := make([][]structure.Coords, len(c.Models))
+	for i, m := range c.Models {
+		models[i] = m.CaAtoms()
+	}
+	meta := pdbMetadata(c.Entry, c.Chain, nil)
+	meta.Extra = map[string]string{"models": strconv.Itoa(len(models))}
+	return Bowed{
+		Id:          c.id(),
+		Meta:        meta,
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         EnsembleBow(lib, models),
+	}
+}
+
+type cifModelStructure struct {
+	cifChainStructure
+	model *pdbx.Model
+}
+
+// BowerFromCifModel provides a reference implementation of the
+// StructureBower interface for a model of a chain in a PDBx/mmCIF formatted
+// file. Its id is the id of the chain followed by the model number, just
+// like BowerFromModel.
+func BowerFromCifModel(c *pdbx.Chain, m *pdbx.Model) StructureBower {
+	return cifModelStructure{cifChainStructure{c, ""}, m}
+}
+
+func (c cifModelStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	meta := cifMetadata(c.Chain, c.source)
+	meta.Model = c.model.Num
+	return Bowed{
+		Id:          fmt.Sprintf("%s%d", c.id(), c.model.Num),
+		Meta:        meta,
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         StructureBow(lib, c.model.AlphaCarbons),
+	}
+}
+
+type cifEnsembleStructure struct {
+	cifChainStructure
+}
+
+// BowerFromCifEnsemble provides a reference implementation of the
+// StructureBower interface for every model of a chain in a PDBx/mmCIF
+// formatted file. Its BOW is the average of the BOWs of every model of the
+// chain. It has the same id as the bower returned by BowerFromCifChain.
+func BowerFromCifEnsemble(c *pdbx.Chain) StructureBower {
+	return cifEnsembleStructure{cifChainStructure{c, ""}}
+}
+
+func (c cifEnsembleStructure) StructureBow(
+	lib fragbag.StructureLibrary,
+) Bowed {
+	models := make([][]structure.Coords, len(c.Models))
+	for i, m := range c.Models {
+		models[i] = m.AlphaCarbons
+	}
+	meta := cifMetadata(c.Chain, c.source)
+	meta.Extra = map[string]string{"models": strconv.Itoa(len(models))}
+	return Bowed{
+		Id:          c.id(),
+		Meta:        meta,
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         EnsembleBow(lib, models),
+	}
+}
+
+// cifMetadata returns meta data for a chain in a PDBx/mmCIF file read from
+// the source given, without a model number.
+func cifMetadata(c *pdbx.Chain, source string) Metadata {
+	return Metadata{
+		Source:   source,
+		Entry:    strings.ToLower(c.Entity.Entry.Id),
+		Chain:    string(c.Id),
+		Sequence: residues(c.Entity.Seq),
+	}
+}
+
+// EnsembleBow is a helper function to compute the average bag-of-words of
+// an ensemble of models, where each model is given as a list of
+// alpha-carbon atoms. (See StructureBow.)
+func EnsembleBow(
+	lib fragbag.StructureLibrary,
+	models [][]structure.Coords,
+) Bow {
+	sum := NewBow(lib.Size())
+	if len(models) == 0 {
+		return sum
+	}
+	for _, atoms := range models {
+		sum = sum.Add(StructureBow(lib, atoms))
+	}
+	for i := range sum.Freqs {
+		sum.Freqs[i] /= float32(len(models))
+	}
+	return sum
+}
@@ -0,0 +1,145 @@
+package bow
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+)
+
+// testStructureLibrary returns a library of two fragments of three atoms.
+func testStructureLibrary(t *testing.T) fragbag.StructureLibrary {
+	frags := [][]structure.Coords{
+		{{0, 0, 0}, {3.8, 0, 0}, {7.6, 0, 0}},
+		{{0, 0, 0}, {3.8, 0, 0}, {3.8, 3.8, 0}},
+	}
+	lib, err := fragbag.NewStructureAtoms("test", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+// testCifEntry returns an mmCIF entry with a single chain 'A' with the
+// models given, which are numbered from 1.
+func testCifEntry(models ...[]structure.Coords) *pdbx.Entry {
+	e := &pdbx.Entry{Id: "1CTF"}
+	entity := &pdbx.Entity{Entry: e, Id: '1', Seq: []seq.Residue("MKV")}
+	chain := &pdbx.Chain{Entity: entity, Id: 'A'}
+	for i, atoms := range models {
+		chain.Models = append(chain.Models,
+			&pdbx.Model{Num: i + 1, AlphaCarbons: atoms})
+	}
+	entity.Chains = []*pdbx.Chain{chain}
+	e.Entities = []*pdbx.Entity{entity}
+	return e
+}
+
+func TestCifBowersSource(t *testing.T) {
+	lib := testStructureLibrary(t)
+	e := testCifEntry([]structure.Coords{{0, 0, 0}, {3.8, 0, 0}, {7.6, 0, 0}})
+	for _, mode := range []ModelMode{FirstModel, EveryModel, EnsembleModels} {
+		for _, b := range CifBowers(e, "1ctf.cif", mode) {
+			meta := b.StructureBow(lib).Meta
+			if meta.Source != "1ctf.cif" || meta.Entry != "1ctf" ||
+				meta.Chain != "A" || meta.Sequence != "MKV" {
+				t.Fatalf("Mode %d: Unexpected meta data %+v.", mode, meta)
+			}
+		}
+	}
+}
+
+// testPdbEntry returns a PDB entry with the chains given, where every chain
+// has models with the numbers given.
+func testPdbEntry(chains string, models ...int) *pdb.Entry {
+	e := &pdb.Entry{Path: "1ctf.pdb", IdCode: "1CTF"}
+	for i := range chains {
+		c := &pdb.Chain{Entry: e, Ident: chains[i]}
+		for _, num := range models {
+			m := &pdb.Model{Entry: e, Chain: c, Num: num}
+			c.Models = append(c.Models, m)
+		}
+		e.Chains = append(e.Chains, c)
+	}
+	return e
+}
+
+func TestModelIds(t *testing.T) {
+	lib := testStructureLibrary(t)
+	ids := func(bowers []StructureBower) []string {
+		var ids []string
+		for _, b := range bowers {
+			ids = append(ids, b.StructureBow(lib).Id)
+		}
+		return ids
+	}
+
+	e := testPdbEntry("AB", 1, 2, 11)
+	expected := []string{
+		"1ctfA1", "1ctfA2", "1ctfA11", "1ctfB1", "1ctfB2", "1ctfB11",
+	}
+	if got := ids(PdbBowers(e, EveryModel)); !reflect.DeepEqual(got,
+		expected) {
+		t.Fatalf("Expected model ids %v but got %v.", expected, got)
+	}
+	expected = []string{"1ctfA", "1ctfB"}
+	for _, mode := range []ModelMode{FirstModel, EnsembleModels} {
+		if got := ids(PdbBowers(e, mode)); !reflect.DeepEqual(got,
+			expected) {
+			t.Fatalf("Mode %d: Expected ids %v but got %v.",
+				mode, expected, got)
+		}
+	}
+
+	// Entries with a SCOP identifier (like ASTRAL domain files, which have
+	// a single chain) still have an id for every model.
+	e = testPdbEntry("A", 1, 2, 11)
+	e.Scop = "d1ctf__"
+	expected = []string{"d1ctf__1", "d1ctf__2", "d1ctf__11"}
+	if got := ids(PdbBowers(e, EveryModel)); !reflect.DeepEqual(got,
+		expected) {
+		t.Fatalf("Expected model ids %v but got %v.", expected, got)
+	}
+
+	atoms := []structure.Coords{{0, 0, 0}, {3.8, 0, 0}, {7.6, 0, 0}}
+	cif := testCifEntry(atoms, atoms)
+	expected = []string{"1ctfA1", "1ctfA2"}
+	if got := ids(CifBowers(cif, "", EveryModel)); !reflect.DeepEqual(got,
+		expected) {
+		t.Fatalf("Expected mmCIF model ids %v but got %v.", expected, got)
+	}
+}
+
+func TestEnsembleBow(t *testing.T) {
+	lib := testStructureLibrary(t)
+	straight := []structure.Coords{{0, 0, 0}, {3.8, 0, 0}, {7.6, 0, 0}}
+	bent := []structure.Coords{{1, 1, 1}, {4.8, 1, 1}, {4.8, 4.8, 1}}
+
+	expected := []float32{0.5, 0.5}
+	b := EnsembleBow(lib, [][]structure.Coords{straight, bent})
+	if !reflect.DeepEqual(b.Freqs, expected) {
+		t.Fatalf("Expected ensemble BOW %v but got %v.", expected, b.Freqs)
+	}
+
+	bowers := CifBowers(testCifEntry(straight, bent), "", EnsembleModels)
+	if len(bowers) != 1 {
+		t.Fatalf("Expected 1 ensemble bower but got %d.", len(bowers))
+	}
+	bowed := bowers[0].StructureBow(lib)
+	if !reflect.DeepEqual(bowed.Bow.Freqs, expected) {
+		t.Fatalf("Expected ensemble BOW %v but got %v.",
+			expected, bowed.Bow.Freqs)
+	}
+	if n, _ := bowed.Meta.Get("models"); n != "2" {
+		t.Fatalf("Expected 2 models in the meta data but got '%s'.", n)
+	}
+
+	if b := EnsembleBow(lib, nil); !reflect.DeepEqual(b.Freqs,
+		[]float32{0, 0}) {
+		t.Fatalf("Expected an empty ensemble BOW but got %v.", b.Freqs)
+	}
+}
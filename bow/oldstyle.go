@@ -1,10 +1,14 @@
 package bow
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // This file segregates several methods the provide interoperability between
@@ -45,6 +49,10 @@ import (
 // preserved by BOW values in this package. Thus, the only way to truly test
 // for equality is to convert Fragbag's output to a BOW using NewOldStyleBow,
 // and using the (Bow).Equal method.
+//
+// Since the format can only represent integral frequencies, the fractional
+// part of every frequency is dropped. Use OldStyleWriter to get an error
+// instead.
 func (b Bow) StringOldStyle() string {
 	buf := new(bytes.Buffer)
 	a, z := int('a'-'a'), int('z'-'a')
@@ -68,6 +76,19 @@ func (b Bow) StringOldStyle() string {
 	return buf.String()
 }
 
+// checkIntegral returns an error if any frequency in the BOW is negative or
+// has a fractional part, since it cannot be represented in the old style
+// format.
+func (b Bow) checkIntegral() error {
+	for i, freq := range b.Freqs {
+		if freq < 0 || freq != float32(math.Trunc(float64(freq))) {
+			return fmt.Errorf("The frequency %f of fragment %d cannot be "+
+				"represented in the old style format.", freq, i)
+		}
+	}
+	return nil
+}
+
 // NewOldStyleBow returns a bag-of-words from Fragbag's original bag-of-words
 // vector output.
 //
@@ -172,3 +193,98 @@ func NewOldStyleBow(size int, oldschool string) (Bow, error) {
 	}
 	return bow, nil
 }
+
+// OldStyleReader reads Bowed values from the output of the old Fragbag
+// program. Each line of the output contains an identifier followed by
+// whitespace and a bag-of-words vector in the format described by
+// (Bow).StringOldStyle. The bag-of-words vector may be omitted, in which case
+// the vector is empty. Blank lines are skipped.
+type OldStyleReader struct {
+	size int
+	buf  *bufio.Reader
+	line int
+}
+
+// NewOldStyleReader returns a reader of old Fragbag output. The size given
+// should be the size of the fragment library used to produce the output.
+func NewOldStyleReader(r io.Reader, size int) *OldStyleReader {
+	return &OldStyleReader{
+		size: size,
+		buf:  bufio.NewReader(r),
+	}
+}
+
+// Read reads the next Bowed value. If there are no more values, io.EOF is
+// returned. If a line is malformed, an error is returned that includes the
+// line number.
+func (r *OldStyleReader) Read() (Bowed, error) {
+	for {
+		line, err := r.buf.ReadString('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return Bowed{}, err
+		}
+		r.line++
+
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			return Bowed{Id: fields[0], Bow: NewBow(r.size)}, nil
+		case 2:
+			b, err := NewOldStyleBow(r.size, fields[1])
+			if err != nil {
+				return Bowed{}, fmt.Errorf("Line %d: %s", r.line, err)
+			}
+			return Bowed{Id: fields[0], Bow: b}, nil
+		default:
+			return Bowed{}, fmt.Errorf("Line %d: Expected an identifier and "+
+				"a bag-of-words, but found %d fields.", r.line, len(fields))
+		}
+	}
+}
+
+// ReadAll reads all remaining Bowed values.
+func (r *OldStyleReader) ReadAll() ([]Bowed, error) {
+	bs := make([]Bowed, 0, 100)
+	for {
+		b, err := r.Read()
+		if err == io.EOF {
+			return bs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+}
+
+// OldStyleWriter writes Bowed values in the format read by OldStyleReader.
+// Flush must be called when done writing.
+type OldStyleWriter struct {
+	buf *bufio.Writer
+}
+
+// NewOldStyleWriter returns a writer of old Fragbag output.
+func NewOldStyleWriter(w io.Writer) *OldStyleWriter {
+	return &OldStyleWriter{bufio.NewWriter(w)}
+}
+
+// Write writes a single Bowed value on its own line. The identifier must
+// not contain any whitespace, and every frequency of the BOW must be
+// integral. (BOWs computed with weighted libraries usually are not.)
+func (w *OldStyleWriter) Write(b Bowed) error {
+	if len(b.Id) == 0 || strings.IndexFunc(b.Id, unicode.IsSpace) > -1 {
+		return fmt.Errorf("The identifier '%s' cannot be written since it "+
+			"is empty or contains whitespace.", b.Id)
+	}
+	if err := b.Bow.checkIntegral(); err != nil {
+		return fmt.Errorf("The BOW of '%s' cannot be written: %s", b.Id, err)
+	}
+	_, err := fmt.Fprintf(w.buf, "%s\t%s\n", b.Id, b.Bow.StringOldStyle())
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *OldStyleWriter) Flush() error {
+	return w.buf.Flush()
+}
@@ -1,6 +1,7 @@
 package bow
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
@@ -89,3 +90,47 @@ func TestOldStyleWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestOldStyleFile(t *testing.T) {
+	bs := make([]Bowed, len(newstyle))
+	for i, b := range newstyle {
+		bs[i] = Bowed{Id: fmt.Sprintf("entry%d", i), Bow: b}
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewOldStyleWriter(buf)
+	for _, b := range bs {
+		if err := w.Write(b); err != nil {
+			t.Fatalf("Could not write '%s': %s", b.Id, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := NewOldStyleReader(buf, library.Size()).ReadAll()
+	if err != nil {
+		t.Fatalf("Could not read old style output: %s", err)
+	}
+	if len(read) != len(bs) {
+		t.Fatalf("Expected %d BOWs but read %d.", len(bs), len(read))
+	}
+	for i, b := range read {
+		if b.Id != bs[i].Id || !b.Bow.Equal(bs[i].Bow) {
+			t.Fatalf("The BOW '%s' (%s) read is not equivalent to the BOW "+
+				"'%s' (%s) written.", b.Id, b.Bow, bs[i].Id, bs[i].Bow)
+		}
+	}
+}
+
+func TestOldStyleFractional(t *testing.T) {
+	b := Bowed{Id: "weighted", Bow: newBowMap(60, map[int]float32{
+		3:  2,
+		55: 1.5,
+	})}
+	w := NewOldStyleWriter(new(bytes.Buffer))
+	if err := w.Write(b); err == nil {
+		t.Fatal("Expected an error writing a BOW with a fractional " +
+			"frequency.")
+	}
+}
@@ -0,0 +1,253 @@
+package bow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/seq"
+)
+
+// aminoAcids is the alphabet of profiles computed from alignments.
+var aminoAcids = seq.Alphabet("ACDEFGHIKLMNPQRSTVWY")
+
+// ReadAlignment reads a multiple sequence alignment in aligned FASTA or A3M
+// format, where the first sequence is the query. Lines starting with '#'
+// are ignored. Residues are kept as they are, so that lowercase insertions
+// and '.' gaps in A3M files are preserved. (See ProfileFromAlignment.)
+func ReadAlignment(r io.Reader) ([]seq.Sequence, error) {
+	var msa []seq.Sequence
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0 || line[0] == '#':
+			continue
+		case line[0] == '>':
+			name := strings.TrimSpace(line[1:])
+			msa = append(msa, seq.Sequence{Name: name})
+			continue
+		case len(msa) == 0:
+			return nil, fmt.Errorf("Line %d: Residues found before the "+
+				"first sequence header.", lineNum)
+		}
+		last := &msa[len(msa)-1]
+		for i := 0; i < len(line); i++ {
+			last.Residues = append(last.Residues, seq.Residue(line[i]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return msa, nil
+}
+
+// ProfileFromAlignment computes a profile of the amino acids in a multiple
+// sequence alignment, where the first sequence is the query. The profile has
+// one column for every residue of the query.
+//
+// Lowercase residues and '.' characters are insertions relative to the query
+// (as in A3M files), and are removed before the sequences are aligned by
+// column. Columns in which the query has a gap are removed too, which makes
+// this work for alignments in aligned FASTA format as well. Every sequence in
+// the alignment has the same weight, and gaps and residues that aren't amino
+// acids are not counted.
+//
+// Emission probabilities are estimated from the residue counts of each
+// column, where `pseudo` pseudocounts are distributed uniformly over the
+// amino acids. A column without any counts emits every amino acid with the
+// same probability.
+func ProfileFromAlignment(
+	msa []seq.Sequence,
+	pseudo float64,
+) (*seq.Profile, error) {
+	if len(msa) == 0 {
+		return nil, fmt.Errorf("Cannot compute the profile of an empty " +
+			"alignment.")
+	}
+
+	aligned := make([][]seq.Residue, len(msa))
+	for i, s := range msa {
+		for _, r := range s.Residues {
+			if !isInsertion(r) {
+				aligned[i] = append(aligned[i], r)
+			}
+		}
+		if len(aligned[i]) != len(aligned[0]) {
+			return nil, fmt.Errorf("Sequence '%s' has %d aligned columns, "+
+				"but the query '%s' has %d.", s.Name, len(aligned[i]),
+				msa[0].Name, len(aligned[0]))
+		}
+	}
+
+	var columns []int
+	for c, r := range aligned[0] {
+		if r != '-' {
+			columns = append(columns, c)
+		}
+	}
+
+	prof := seq.NewProfileAlphabet(len(columns), aminoAcids)
+	prof.Name = msa[0].Name
+	uniform := 1.0 / float64(len(aminoAcids))
+	for i, c := range columns {
+		counts := make(map[seq.Residue]int, len(aminoAcids))
+		total := 0
+		for _, residues := range aligned {
+			counts[residues[c]]++
+		}
+		for _, r := range aminoAcids {
+			total += counts[r]
+		}
+
+		emit := seq.NewEProbs(aminoAcids)
+		for _, r := range aminoAcids {
+			p := uniform
+			if total > 0 || pseudo > 0 {
+				p = (float64(counts[r]) + pseudo*uniform) /
+					(float64(total) + pseudo)
+			}
+			if p <= 0 {
+				emit.Set(r, seq.MinProb)
+			} else {
+				emit.Set(r, seq.Prob(-math.Log(p)))
+			}
+		}
+		prof.Emissions[i] = emit
+	}
+	return prof, nil
+}
+
+// isInsertion returns true if the residue given is an insertion relative to
+// the query of an A3M alignment.
+func isInsertion(r seq.Residue) bool {
+	return r == '.' || (r >= 'a' && r <= 'z')
+}
+
+type profile struct {
+	*seq.Profile
+}
+
+// BowerFromProfile provides a reference implementation of the SequenceBower
+// interface for sequence profiles. Each window of the profile is compared
+// with the fragments of a sequence library by fragbag.BestProfileFragment.
+func BowerFromProfile(p *seq.Profile) SequenceBower {
+	return profile{p}
+}
+
+func (p profile) SequenceBow(lib fragbag.SequenceLibrary) Bowed {
+	return Bowed{
+		Id:          firstField(p.Name),
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         ProfileBow(lib, p.Profile),
+	}
+}
+
+type alignment struct {
+	query   seq.Sequence
+	size    int
+	profile *seq.Profile
+}
+
+// BowerFromAlignment provides a reference implementation of the
+// SequenceBower interface for multiple sequence alignments, where the first
+// sequence is the query. Its BOW is the BOW of the profile of the alignment
+// (see ProfileFromAlignment), which is computed without pseudocounts. (So
+// that with a sequence profile library, an alignment of a single sequence of
+// amino acids has the same BOW as the sequence.)
+//
+// Its id, data and sequence are those of the query (like BowerFromSequence),
+// and the number of sequences in the alignment is recorded in its meta data.
+func BowerFromAlignment(msa []seq.Sequence) (SequenceBower, error) {
+	prof, err := ProfileFromAlignment(msa, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var residues []seq.Residue
+	for _, r := range msa[0].Residues {
+		if r != '-' && !isInsertion(r) {
+			residues = append(residues, r)
+		}
+	}
+	query := seq.Sequence{Name: msa[0].Name, Residues: residues}
+	return alignment{query, len(msa), prof}, nil
+}
+
+func (a alignment) SequenceBow(lib fragbag.SequenceLibrary) Bowed {
+	return Bowed{
+		Id:   firstField(a.query.Name),
+		Data: a.query.Bytes(),
+		Meta: Metadata{
+			Sequence: residues(a.query.Residues),
+			Extra:    map[string]string{"sequences": strconv.Itoa(a.size)},
+		},
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         ProfileBow(lib, a.profile),
+	}
+}
+
+// firstField returns the first whitespace separated field of the name given,
+// or an empty string if there is none.
+func firstField(name string) string {
+	if fields := strings.Fields(name); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// ProfileBow is a helper function to compute a bag-of-words given a
+// sequence fragment library and a query profile.
+//
+// If the lib given is a weighted library, then the BOW returned will also
+// be weighted.
+//
+// Note that this function should only be used when providing your own
+// implementation of the SequenceBower interface. Otherwise, BOWs should
+// be computed using the SequenceBow method of the interface.
+func ProfileBow(lib fragbag.SequenceLibrary, p *seq.Profile) Bow {
+	b, _ := ProfileBowContext(context.Background(), lib, p, nil)
+	return b
+}
+
+// ProfileBowContext is like ProfileBow, except it stops and returns the
+// context's error if the context is cancelled. Progress is reported with the
+// number of windows of the profile compared with the fragment library. The
+// progress function may be nil.
+func ProfileBowContext(
+	ctx context.Context,
+	lib fragbag.SequenceLibrary,
+	p *seq.Profile,
+	progress Progress,
+) (Bow, error) {
+	var best, uplimit int
+
+	b := NewBow(lib.Size())
+	libSize := lib.FragmentSize()
+	uplimit = p.Len() - libSize
+	for i := 0; i <= uplimit; i++ {
+		if err := ctx.Err(); err != nil {
+			return Bow{}, err
+		}
+		window := &seq.Profile{
+			Name:      p.Name,
+			Emissions: p.Emissions[i : i+libSize],
+			Alphabet:  p.Alphabet,
+		}
+		best = fragbag.BestProfileFragment(lib, window)
+		if best >= 0 {
+			b.Freqs[best] += 1
+		}
+		progress.Report(i+1, uplimit+1)
+	}
+	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
+		b = b.Weighted(wlib)
+	}
+	return b, nil
+}
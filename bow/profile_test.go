@@ -0,0 +1,77 @@
+package bow
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/seq"
+)
+
+// testSequenceLibrary returns a library of profiles with two columns over
+// the amino acids, where fragment i emits the residue favored[i] with
+// probability 0.9 and every other amino acid with the same probability.
+func testSequenceLibrary(
+	t *testing.T,
+	null *seq.EProbs,
+	favored ...seq.Residue,
+) fragbag.SequenceLibrary {
+	frags := make([]*seq.Profile, len(favored))
+	other := 0.1 / float64(len(aminoAcids)-1)
+	for i, fav := range favored {
+		frags[i] = seq.NewProfileAlphabet(2, aminoAcids)
+		for c := range frags[i].Emissions {
+			ep := seq.NewEProbs(aminoAcids)
+			for _, r := range aminoAcids {
+				p := other
+				if r == fav {
+					p = 0.9
+				}
+				ep.Set(r, seq.Prob(-math.Log(p)))
+			}
+			frags[i].Emissions[c] = ep
+		}
+	}
+	lib, err := fragbag.NewSequenceProfileLogOdds("test", frags, null, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func TestAlignmentOfOneSequence(t *testing.T) {
+	uniform := seq.NewEProbs(aminoAcids)
+	for _, r := range aminoAcids {
+		uniform.Set(r, seq.Prob(math.Log(float64(len(aminoAcids)))))
+	}
+	libs := map[string]fragbag.SequenceLibrary{
+		"without background": testSequenceLibrary(t, nil, 'A', 'C', 'W'),
+		"with background":    testSequenceLibrary(t, &uniform, 'A', 'C', 'W'),
+	}
+	s := seq.NewSequenceString("query desc", "AACCWWAKCW")
+	for name, lib := range libs {
+		bower, err := BowerFromAlignment([]seq.Sequence{s})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := bower.SequenceBow(lib)
+		expected := BowerFromSequence(s).SequenceBow(lib)
+		if expected.Bow.Freqs[0] == 0 || expected.Bow.Freqs[2] == 0 {
+			t.Fatalf("%s: Expected windows of the sequence to match the "+
+				"first and last fragments, but got BOW %v.",
+				name, expected.Bow.Freqs)
+		}
+		if !reflect.DeepEqual(got.Bow.Freqs, expected.Bow.Freqs) {
+			t.Fatalf("%s: Expected the BOW %v of the sequence but got %v.",
+				name, expected.Bow.Freqs, got.Bow.Freqs)
+		}
+		if got.Id != expected.Id ||
+			got.Meta.Sequence != expected.Meta.Sequence ||
+			!reflect.DeepEqual(got.Data, expected.Data) {
+			t.Fatalf("%s: Expected id '%s' and sequence '%s' but got '%s' "+
+				"and '%s'.", name, expected.Id, expected.Meta.Sequence,
+				got.Id, got.Meta.Sequence)
+		}
+	}
+}
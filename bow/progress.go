@@ -0,0 +1,13 @@
+package bow
+
+// Progress is a function called by long running operations to report their
+// progress. 'done' is the number of items processed so far and 'total' is the
+// total number of items, or -1 if the total is not known.
+type Progress func(done, total int)
+
+// Report calls the progress function if it is not nil.
+func (p Progress) Report(done, total int) {
+	if p != nil {
+		p(done, total)
+	}
+}
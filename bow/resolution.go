@@ -0,0 +1,92 @@
+package bow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+)
+
+// ReadResolution reads the resolution in Angstroms of the PDB or mmCIF file
+// given. (Resolutions are read directly from the file, since they aren't
+// kept by the pdb or pdbx packages.) In PDB files, it is read from the
+// "REMARK   2 RESOLUTION." record. In mmCIF files, it is read from the
+// _refine.ls_d_res_high item or, if that is missing, from the
+// _reflns.d_resolution_high item. Zero is returned if the file has no
+// resolution, like NMR structures.
+//
+// Reading stops at the first atom record, since the resolution precedes
+// the coordinates in both formats.
+func ReadResolution(r io.Reader) (float64, error) {
+	const remark = "REMARK   2 RESOLUTION."
+	items := []string{"_refine.ls_d_res_high", "_reflns.d_resolution_high"}
+	found := make([]float64, len(items))
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20) // mmCIF files may have long lines
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "ATOM") {
+			break
+		}
+		if strings.HasPrefix(line, remark) {
+			fields := strings.Fields(line[len(remark):])
+			if len(fields) == 0 || fields[0] == "NOT" { // NOT APPLICABLE.
+				return 0, nil
+			}
+			res, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return 0, fmt.Errorf("Line %d: Invalid resolution '%s'.",
+					lineNum, fields[0])
+			}
+			return res, nil
+		}
+		fields := strings.Fields(line)
+		for i, item := range items {
+			if len(fields) != 2 || fields[0] != item {
+				continue
+			}
+			if fields[1] == "?" || fields[1] == "." { // unknown
+				continue
+			}
+			res, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("Line %d: Invalid resolution '%s'.",
+					lineNum, fields[1])
+			}
+			found[i] = res
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	for _, res := range found {
+		if res > 0 {
+			return res, nil
+		}
+	}
+	return 0, nil
+}
+
+type resolutionStructure struct {
+	StructureBower
+	resolution float64
+}
+
+// BowerWithResolution returns a structure bower that records the resolution
+// given (see ReadResolution) in the meta data of the BOWs computed by the
+// bower given.
+func BowerWithResolution(b StructureBower, resolution float64) StructureBower {
+	return resolutionStructure{b, resolution}
+}
+
+func (s resolutionStructure) StructureBow(
+	lib fragbag.StructureLibrary,
+) Bowed {
+	b := s.StructureBower.StructureBow(lib)
+	b.Meta.Resolution = s.resolution
+	return b
+}
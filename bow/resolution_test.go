@@ -0,0 +1,55 @@
+package bow
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadResolution(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		expected float64
+	}{
+		{"pdb",
+			"HEADER    RIBOSOMAL PROTEIN\n" +
+				"REMARK   2\n" +
+				"REMARK   2 RESOLUTION.    1.70 ANGSTROMS.\n" +
+				"ATOM      1  N   MET A   1       1.000   2.000   3.000\n",
+			1.7},
+		{"pdb nmr",
+			"REMARK   2 RESOLUTION. NOT APPLICABLE.\n", 0},
+		{"pdb without resolution", "HEADER    RIBOSOMAL PROTEIN\n", 0},
+		{"mmcif refine",
+			"data_1CTF\n" +
+				"_reflns.d_resolution_high   1.80\n" +
+				"_refine.ls_d_res_high       1.70\n", 1.7},
+		{"mmcif reflns",
+			"data_1CTF\n" +
+				"_reflns.d_resolution_high   1.80\n" +
+				"_refine.ls_d_res_high       ?\n", 1.8},
+		{"after atoms",
+			"ATOM      1  N   MET A   1       1.000   2.000   3.000\n" +
+				"REMARK   2 RESOLUTION.    1.70 ANGSTROMS.\n", 0},
+	}
+	for _, test := range tests {
+		got, err := ReadResolution(strings.NewReader(test.file))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if got != test.expected {
+			t.Fatalf("%s: Expected resolution %f but got %f.",
+				test.name, test.expected, got)
+		}
+	}
+
+	invalid := []string{
+		"REMARK   2 RESOLUTION.    x.70 ANGSTROMS.\n",
+		"_refine.ls_d_res_high       high\n",
+	}
+	for _, file := range invalid {
+		if _, err := ReadResolution(strings.NewReader(file)); err == nil {
+			t.Fatalf("Expected an error for '%s'.", file)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package bow
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -26,6 +27,14 @@ type Bowed struct {
 	// Arbitrary data associated with the source. May be empty.
 	Data []byte
 
+	// Structured meta data about the source. May be empty.
+	Meta Metadata
+
+	// The fingerprint of the fragment library used to compute the
+	// bag-of-words (see fragbag.Fingerprint). May be empty, in which case
+	// the library is unknown.
+	Fingerprint string
+
 	// The bag-of-words.
 	Bow Bow
 }
@@ -66,10 +75,37 @@ func (c pdbChainStructure) id() string {
 }
 
 func (c pdbChainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	var first *pdb.Model
+	if len(c.Models) > 0 {
+		first = c.Models[0]
+	}
 	return Bowed{
-		Id:  c.id(),
-		Bow: StructureBow(lib, c.CaAtoms()),
+		Id:          c.id(),
+		Meta:        pdbMetadata(c.Entry, c.Chain, first),
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         StructureBow(lib, c.CaAtoms()),
+	}
+}
+
+// pdbMetadata returns meta data for a model of a chain in a PDB entry. The
+// model may be nil.
+func pdbMetadata(e *pdb.Entry, c *pdb.Chain, m *pdb.Model) Metadata {
+	meta := Metadata{
+		Source:   e.Path,
+		Entry:    strings.ToLower(e.IdCode),
+		Chain:    string(c.Ident),
+		Sequence: residues(c.Sequence),
+		Scop:     e.Scop,
+		Cath:     e.Cath,
 	}
+	if m != nil {
+		meta.Model = m.Num
+		if len(m.Residues) > 0 {
+			meta.Start = m.Residues[0].SequenceNum
+			meta.End = m.Residues[len(m.Residues)-1].SequenceNum
+		}
+	}
+	return meta
 }
 
 type pdbModelStructure struct {
@@ -77,37 +113,36 @@ type pdbModelStructure struct {
 }
 
 // BowerFromModel provides a reference implementation of the StructureBower
-// interface for PDB models.
+// interface for PDB models. Its id is the id of the bower returned by
+// BowerFromChain for its chain followed by the model number, so that every
+// model of a chain has a different id.
 func BowerFromModel(c *pdb.Model) StructureBower {
 	return pdbModelStructure{c}
 }
 
 func (m pdbModelStructure) id() string {
-	switch {
-	case len(m.Entry.Scop) > 0:
-		return m.Entry.Scop
-	case len(m.Entry.Cath) > 0:
-		return m.Entry.Cath
-	}
-	return fmt.Sprintf("%s%c%d",
-		strings.ToLower(m.Entry.IdCode), m.Chain.Ident, m.Num)
+	return fmt.Sprintf("%s%d", pdbChainStructure{m.Chain}.id(), m.Num)
 }
 
 func (m pdbModelStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
 	return Bowed{
-		Id:  m.id(),
-		Bow: StructureBow(lib, m.CaAtoms()),
+		Id:          m.id(),
+		Meta:        pdbMetadata(m.Entry, m.Chain, m.Model),
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         StructureBow(lib, m.CaAtoms()),
 	}
 }
 
 type cifChainStructure struct {
 	*pdbx.Chain
+	source string
 }
 
 // BowerFromCifChain provides a reference implementation of the StructureBower
-// interface for chains in PDBx/mmCIF formatted files.
+// interface for chains in PDBx/mmCIF formatted files. (Use CifBowers to
+// record the path of the file in the meta data of its BOW.)
 func BowerFromCifChain(c *pdbx.Chain) StructureBower {
-	return cifChainStructure{c}
+	return cifChainStructure{c, ""}
 }
 
 func (c cifChainStructure) id() string {
@@ -115,9 +150,13 @@ func (c cifChainStructure) id() string {
 }
 
 func (c cifChainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	meta := cifMetadata(c.Chain, c.source)
+	meta.Model = c.Models[0].Num
 	return Bowed{
-		Id:  c.id(),
-		Bow: StructureBow(lib, c.Models[0].AlphaCarbons),
+		Id:          c.id(),
+		Meta:        meta,
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         StructureBow(lib, c.Models[0].AlphaCarbons),
 	}
 }
 
@@ -131,21 +170,105 @@ func (c cifChainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
 // implementation of the StructureBower interface. Otherwise, BOWs should
 // be computed using the StructureBow method of the interface.
 func StructureBow(lib fragbag.StructureLibrary, atoms []structure.Coords) Bow {
-	var best, uplimit int
+	b, _ := StructureBowContext(context.Background(), lib, atoms, nil)
+	return b
+}
+
+// StructureBowContext is like StructureBow, except it stops and returns the
+// context's error if the context is cancelled. Progress is reported with the
+// number of windows of atoms compared with the fragment library. The
+// progress function may be nil.
+func StructureBowContext(
+	ctx context.Context,
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+	progress Progress,
+) (Bow, error) {
+	b := NewBow(lib.Size())
+	if err := countStructure(ctx, lib, atoms, b, progress); err != nil {
+		return Bow{}, err
+	}
+	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
+		b = b.Weighted(wlib)
+	}
+	return b, nil
+}
+
+// StructureBowSegments is like StructureBow, except the alpha-carbon atoms
+// are given as a list of segments (like the discontinuous segments of a
+// domain). Fragments are only matched within each segment, so that no
+// fragment spans two segments.
+func StructureBowSegments(
+	lib fragbag.StructureLibrary,
+	segments [][]structure.Coords,
+) Bow {
+	b, _ := StructureBowSegmentsContext(context.Background(), lib, segments,
+		nil)
+	return b
+}
+
+// StructureBowSegmentsContext is like StructureBowSegments, except it stops
+// and returns the context's error if the context is cancelled. Progress is
+// reported with the number of windows of atoms compared with the fragment
+// library, over all segments. The progress function may be nil.
+func StructureBowSegmentsContext(
+	ctx context.Context,
+	lib fragbag.StructureLibrary,
+	segments [][]structure.Coords,
+	progress Progress,
+) (Bow, error) {
+	// windows returns the number of fragment windows in a segment.
+	windows := func(atoms []structure.Coords) int {
+		if n := len(atoms) - lib.FragmentSize() + 1; n > 0 {
+			return n
+		}
+		return 0
+	}
+	total := 0
+	for _, atoms := range segments {
+		total += windows(atoms)
+	}
 
 	b := NewBow(lib.Size())
+	done := 0
+	for _, atoms := range segments {
+		report := func(n, _ int) { progress.Report(done+n, total) }
+		if err := countStructure(ctx, lib, atoms, b, report); err != nil {
+			return Bow{}, err
+		}
+		done += windows(atoms)
+	}
+	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
+		b = b.Weighted(wlib)
+	}
+	return b, nil
+}
+
+// countStructure adds the best fragment of every window of the atoms given
+// to the unweighted BOW given. (See StructureBowContext for the context and
+// progress.)
+func countStructure(
+	ctx context.Context,
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+	b Bow,
+	progress Progress,
+) error {
+	var best, uplimit int
+
 	libSize := lib.FragmentSize()
 	uplimit = len(atoms) - libSize
 	for i := 0; i <= uplimit; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		best = lib.BestStructureFragment(atoms[i : i+libSize])
 		if best > -1 {
 			b.Freqs[best] += 1
 		}
+		progress.Report(i+1, uplimit+1)
 	}
-	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
-		b = b.Weighted(wlib)
-	}
-	return b
+	return nil
 }
 
 // SequenceBower corresponds to Bower values that can provide BOWs given
@@ -160,16 +283,19 @@ type sequence struct {
 }
 
 // BowerFromSequence provides a reference implementation of the SequenceBower
-// interface for biological sequences.
+// interface for biological sequences. The sequence is recorded both as the
+// data of its BOW (as it always has been) and in its meta data.
 func BowerFromSequence(s seq.Sequence) SequenceBower {
 	return sequence{s}
 }
 
 func (s sequence) SequenceBow(lib fragbag.SequenceLibrary) Bowed {
 	return Bowed{
-		Id:   strings.Fields(s.Name)[0],
-		Data: s.Bytes(),
-		Bow:  SequenceBow(lib, s.Sequence),
+		Id:          strings.Fields(s.Name)[0],
+		Data:        s.Bytes(),
+		Meta:        Metadata{Sequence: residues(s.Residues)},
+		Fingerprint: fragbag.Fingerprint(lib),
+		Bow:         SequenceBow(lib, s.Sequence),
 	}
 }
 
@@ -179,24 +305,49 @@ func (s sequence) SequenceBow(lib fragbag.SequenceLibrary) Bowed {
 // If the lib given is a weighted library, then the BOW returned will also
 // be weighted.
 //
+// Windows of the sequence for which the library finds no fragment (i.e.,
+// BestSequenceFragment returns -1) do not contribute to the BOW. This is
+// intended: a sequence profile library with a background distribution (see
+// fragbag.NewSequenceProfileLogOdds and fragbag.SequenceBuilder) finds no
+// fragment for windows that are more likely under the background than under
+// every fragment. So the sum of the frequencies of such a BOW may be less
+// than the number of windows, unlike the BOW of the corresponding structure.
+//
 // Note that this function should only be used when providing your own
 // implementation of the SequenceBower interface. Otherwise, BOWs should
 // be computed using the SequenceBow method of the interface.
 func SequenceBow(lib fragbag.SequenceLibrary, s seq.Sequence) Bow {
+	b, _ := SequenceBowContext(context.Background(), lib, s, nil)
+	return b
+}
+
+// SequenceBowContext is like SequenceBow, except it stops and returns the
+// context's error if the context is cancelled. Progress is reported with the
+// number of windows of the sequence compared with the fragment library. The
+// progress function may be nil.
+func SequenceBowContext(
+	ctx context.Context,
+	lib fragbag.SequenceLibrary,
+	s seq.Sequence,
+	progress Progress,
+) (Bow, error) {
 	var best, uplimit int
 
 	b := NewBow(lib.Size())
 	libSize := lib.FragmentSize()
 	uplimit = s.Len() - libSize
 	for i := 0; i <= uplimit; i++ {
+		if err := ctx.Err(); err != nil {
+			return Bow{}, err
+		}
 		best = lib.BestSequenceFragment(s.Slice(i, i+libSize))
-		if best < 0 {
-			continue
+		if best >= 0 {
+			b.Freqs[best] += 1
 		}
-		b.Freqs[best] += 1
+		progress.Report(i+1, uplimit+1)
 	}
 	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
 		b = b.Weighted(wlib)
 	}
-	return b
+	return b, nil
 }
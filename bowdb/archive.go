@@ -0,0 +1,138 @@
+package bowdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+
+	"github.com/TuftsBCB/fragbag"
+)
+
+// section is the location of a file's contents in a BOW database archive.
+type section struct {
+	offset, size int64
+}
+
+// archive is a BOW database archive on disk. Files in the archive are
+// located by their name, so that the order of files does not matter.
+// Directories are skipped.
+type archive struct {
+	f        *os.File
+	sections map[string]section
+}
+
+// openArchive locates every file in the BOW database archive given. No file
+// contents are read.
+func openArchive(f *os.File) (*archive, error) {
+	a := &archive{f, make(map[string]section, 4)}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		// The tar reader seeks past the contents of files, so the current
+		// position is the start of this file's contents.
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(hdr.Name)
+		if _, ok := a.sections[name]; ok {
+			return nil, fmt.Errorf("More than one '%s' file found.", name)
+		}
+		a.sections[name] = section{offset, hdr.Size}
+	}
+	if !a.has(fileFragLib) {
+		return nil, fmt.Errorf("Could not find '%s' file.", fileFragLib)
+	}
+	return a, nil
+}
+
+// has returns true if the archive contains a file with the name given.
+func (a *archive) has(name string) bool {
+	_, ok := a.sections[name]
+	return ok
+}
+
+// read reads the entire contents of the file with the name given.
+func (a *archive) read(name string) ([]byte, error) {
+	s, ok := a.sections[name]
+	if !ok {
+		return nil, fmt.Errorf("Could not find '%s' file.", name)
+	}
+	bs := make([]byte, s.size)
+	if _, err := a.f.ReadAt(bs, s.offset); err != nil {
+		return nil, fmt.Errorf("Could not read '%s': %s", name, err)
+	}
+	return bs, nil
+}
+
+// manifest decodes the manifest in the archive. If the archive does not have
+// a manifest, then a manifest for version 1 of the format is returned.
+func (a *archive) manifest() (Manifest, error) {
+	var m Manifest
+	if !a.has(fileManifest) {
+		return Manifest{Version: 1}, nil
+	}
+	bs, err := a.read(fileManifest)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return m, fmt.Errorf("Could not read '%s': %s", fileManifest, err)
+	}
+	if m.Version < 2 || m.Version > FormatVersion {
+		return m, fmt.Errorf("Unsupported BOW database format version %d. "+
+			"Only versions up to %d are supported.", m.Version, FormatVersion)
+	}
+	return m, nil
+}
+
+// library reads the fragment library in the archive.
+func (a *archive) library() (fragbag.Library, error) {
+	bs, err := a.read(fileFragLib)
+	if err != nil {
+		return nil, err
+	}
+	lib, err := fragbag.Open(bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read fragment library: %s", err)
+	}
+	return lib, nil
+}
+
+// records returns the uncompressed contents of the 'bow.db' file in the
+// archive, which is decompressed with the codec recorded in the manifest
+// given.
+func (a *archive) records(m Manifest) ([]byte, error) {
+	bs, err := a.read(fileBowDB)
+	if err != nil || len(m.Codec) == 0 {
+		return bs, err
+	}
+	codec, err := LookupCodec(m.Codec)
+	if err != nil {
+		return nil, err
+	}
+	r, err := codec.NewReader(bytes.NewReader(bs))
+	if err == nil {
+		defer r.Close()
+		bs, err = ioutil.ReadAll(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not decompress '%s' with %s: %s",
+			fileBowDB, codec.Name(), err)
+	}
+	return bs, nil
+}
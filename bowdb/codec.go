@@ -0,0 +1,72 @@
+package bowdb
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec describes a compression scheme for the BOWs stored in a database.
+// The name of the codec used is recorded in the manifest of a database, so
+// that Open can decompress the database transparently. Codecs other than
+// Gzip must be registered with RegisterCodec before a database using them can
+// be opened.
+type Codec interface {
+	// Name returns a unique name for this codec.
+	Name() string
+
+	// NewWriter returns a writer that compresses everything written to it
+	// into 'w'. The writer returned is closed when writing is finished.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader that decompresses everything read from 'r'.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip is a codec that compresses with gzip using the default compression
+// level.
+var Gzip Codec = gzipCodec{gzip.DefaultCompression}
+
+// codecs is the registry of codecs, keyed by name.
+var codecs = struct {
+	sync.Mutex
+	m map[string]Codec
+}{m: map[string]Codec{Gzip.Name(): Gzip}}
+
+// RegisterCodec makes a codec available for opening databases. If a codec
+// with the same name is already registered, it is replaced.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	codecs.m[c.Name()] = c
+	codecs.Unlock()
+}
+
+// LookupCodec returns the registered codec with the name given. An error is
+// returned if no such codec exists.
+func LookupCodec(name string) (Codec, error) {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	c, ok := codecs.m[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown codec '%s'.", name)
+	}
+	return c, nil
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func (c gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (c gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
@@ -0,0 +1,163 @@
+package bowdb
+
+import (
+	"fmt"
+	path "path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag/bow"
+)
+
+func TestConcurrentAdd(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	fpath := path.Join(t.TempDir(), "test.bowdb")
+	db, err := Create(lib, fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, perWorker = 4, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				e := entries[i%len(entries)]
+				e.Id = fmt.Sprintf("%d-%d", w, i)
+				if err := db.Add(e); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdb, err := Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+	all, err := rdb.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, expected []string
+	for _, e := range all {
+		got = append(got, e.Id)
+	}
+	for w := 0; w < workers; w++ {
+		for i := 0; i < perWorker; i++ {
+			expected = append(expected, fmt.Sprintf("%d-%d", w, i))
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %d entries %v but got %d entries %v.",
+			len(expected), expected, len(got), got)
+	}
+}
+
+func TestConcurrentSearchClose(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	fpath := createDB(t, lib, entries, nil, true)
+	for _, open := range []func(string) (*DB, error){Open, OpenMapped} {
+		db, err := open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every search must either succeed completely or fail with
+		// ErrClosed, no matter when Close is called.
+		var wg sync.WaitGroup
+		errs := make(chan error, len(entries))
+		for _, query := range entries {
+			wg.Add(1)
+			go func(query bow.Bowed) {
+				defer wg.Done()
+				for {
+					results, err := db.Search(SearchDefault, query)
+					if err == ErrClosed {
+						return
+					} else if err != nil {
+						errs <- err
+						return
+					}
+					if len(results) != len(entries) {
+						errs <- fmt.Errorf("Expected %d results for '%s' "+
+							"but got %d.", len(entries), query.Id,
+							len(results))
+						return
+					}
+				}
+			}(query)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	fpath := createDB(t, lib, entries, nil, true)
+
+	wdb, err := Create(lib, path.Join(t.TempDir(), "new.bowdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wdb.Add(entries[0]); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed adding to a closed database, but got "+
+			"%v.", err)
+	}
+	if err := wdb.Close(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed closing a database twice, but got %v.",
+			err)
+	}
+
+	for _, open := range []func(string) (*DB, error){Open, OpenMapped} {
+		db, err := open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Search(SearchDefault, entries[0]); err != ErrClosed {
+			t.Fatalf("Expected ErrClosed searching a closed database, but "+
+				"got %v.", err)
+		}
+		if _, err := db.ReadAll(); err != ErrClosed {
+			t.Fatalf("Expected ErrClosed reading a closed database, but "+
+				"got %v.", err)
+		}
+		if err := db.Close(); err != ErrClosed {
+			t.Fatalf("Expected ErrClosed closing a database twice, but "+
+				"got %v.", err)
+		}
+	}
+}
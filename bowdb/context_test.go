@@ -0,0 +1,84 @@
+package bowdb
+
+import (
+	"context"
+	path "path/filepath"
+	"testing"
+)
+
+func TestReadAllCancel(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	fpath := createDB(t, lib, entries, nil, true)
+	for _, open := range []func(string) (*DB, error){Open, OpenMapped} {
+		db, err := open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := db.ReadAllContext(ctx, nil); err != context.Canceled {
+			t.Fatalf("Expected a cancelled read, but got %v.", err)
+		}
+
+		// A cancelled read does not prevent reading again.
+		all, err := db.ReadAll()
+		if err != nil {
+			t.Fatalf("Could not read after cancelling: %s", err)
+		}
+		assertEntries(t, "read after cancelling", entries, all)
+	}
+}
+
+func TestSearchCancel(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	fpath := createDB(t, lib, entries, nil, true)
+	for _, open := range []func(string) (*DB, error){Open, OpenMapped} {
+		db, err := open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = db.SearchContext(ctx, SearchDefault, entries[0], nil)
+		if err != context.Canceled {
+			t.Fatalf("Expected a cancelled search, but got %v.", err)
+		}
+		results, err := db.Search(SearchDefault, entries[0])
+		if err != nil {
+			t.Fatalf("Could not search after cancelling: %s", err)
+		}
+		if len(results) != len(entries) {
+			t.Fatalf("Expected %d results after cancelling but got %d.",
+				len(entries), len(results))
+		}
+	}
+}
+
+func TestAddCancel(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	fpath := path.Join(t.TempDir(), "test.bowdb")
+	db, err := Create(lib, fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.AddContext(ctx, entries[0]); err != context.Canceled {
+		t.Fatalf("Expected a cancelled add, but got %v.", err)
+	}
+	if err := db.Add(entries[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertDB(t, "cancelled add", fpath, false, entries[1:2])
+}
@@ -4,10 +4,12 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"os"
 	path "path/filepath"
@@ -20,14 +22,27 @@ import (
 )
 
 const (
-	fileBowDB   = "bow.db"
-	fileFragLib = "frag-lib.json"
+	fileBowDB    = "bow.db"
+	fileFragLib  = "frag-lib.json"
+	fileManifest = "manifest.json"
+	fileFixed    = "bow.fixed"
 )
 
+// progressInterval is the number of entries processed between checking for
+// cancellation and reporting progress.
+const progressInterval = 1024
+
+// ErrClosed is returned when a database is used after it has been closed.
+var ErrClosed = errors.New("The BOW database is closed.")
+
 // DB represents a BOW database. It is always connected to a particular
 // fragment library. In particular, the disk representation of the database is
-// a directory with a copy of the fragment library used to create the database
-// and a binary formatted file of all the frequency vectors computed.
+// a directory with a manifest, a copy of the fragment library used to create
+// the database and a binary formatted file of all the frequency vectors
+// computed.
+//
+// All methods of a DB are safe to call from multiple goroutines. Once a DB
+// is closed, its methods return ErrClosed.
 type DB struct {
 	// The fragment library used to make this database.
 	Lib fragbag.Library
@@ -36,11 +51,41 @@ type DB struct {
 	// name of the database's file path).
 	Name string
 
+	// Parameters recorded in the manifest of the database. When creating a
+	// database, they may be set any time before calling Close.
+	Params map[string]string
+
+	// When creating a database, setting Mappable before calling Close adds
+	// a fixed layout section to the database, which stores every BOW as a
+	// dense vector. Such a database can be opened with OpenMapped.
+	Mappable bool
+
+	// The fingerprint of the fragment library used to make this database.
+	fingerprint string
+
+	// The manifest read from disk, or the manifest being built when
+	// writing.
+	manifest Manifest
+
+	// The codec used to compress BOWs when writing. It may be nil.
+	codec Codec
+
+	// The fixed layout section, when opened with OpenMapped.
+	fixed *fixedSection
+
+	// Protects 'closed'. Every operation holds a read lock while it uses
+	// the database, so that Close cannot release resources in use.
+	mu     sync.RWMutex
+	closed bool
+
 	// The set of entries read from disk when reading a bow DB.
-	// This is populated by ReadAll.
-	entries     []bow.Bowed
-	readAllLock *sync.Mutex // Protects concurrent calls of ReadAll
+	// This is populated exactly once by load, unless loading is cancelled.
+	entries []bow.Bowed
+	loadMu  sync.Mutex // Protects loaded, loadErr and reading entries.
+	loaded  bool
+	loadErr error
 
+	records []byte        // The undecoded entries, until they're loaded.
 	fileBuf *bufio.Reader // A buffer for reading the bow db.
 
 	entryBuf []byte    // Temporary buffer for reading DB entries.
@@ -49,76 +94,210 @@ type DB struct {
 	dataPool []byte    // Memory pool for entry data.
 	dataLast int       // Last index used in data pool.
 
+	out         *os.File       // The file of the writer archive.
 	tw          *tar.Writer    // The writer archive.
 	saveBuf     *bytes.Buffer  // Buffer for bowdb while writing.
-	writeBuf    *bytes.Buffer  // Temporary buffer for binary.
+	writeBuf    *bytes.Buffer  // Temporary buffer for a single record.
+	bowBuf      []byte         // Temporary buffer for an encoded BOW.
 	writingDone chan struct{}  // Indicate when writing is done.
 	entryChan   chan bow.Bowed // Concurrent writing.
+	count       int            // The number of entries written.
+	writeErr    error          // The first error writing an entry.
 }
 
 // Open opens a new BOW database for reading. In particular, all entries
 // in the database will be loaded into memory.
+//
+// The files in the database are located by name, so their order in the
+// archive does not matter. Databases without a manifest can still be opened.
+// Their manifest has version 1 and is otherwise empty. Checksums are not
+// validated by Open; use Verify for that.
 func Open(fpath string) (*DB, error) {
+	return open(fpath, false)
+}
+
+// OpenMapped opens a BOW database for reading by memory mapping its fixed
+// layout section, which is searched in place. This makes opening a database
+// nearly instantaneous, and multiple processes searching the same database
+// share its memory. An error is returned if the database does not have a
+// fixed layout section (see DB.Mappable).
+//
+// On platforms that do not support memory mapping, the fixed layout section
+// is read into memory instead.
+func OpenMapped(fpath string) (*DB, error) {
+	return open(fpath, true)
+}
+
+func open(fpath string, mapped bool) (*DB, error) {
 	var err error
 
-	db := &DB{
-		Name:        path.Base(fpath),
-		readAllLock: new(sync.Mutex),
-	}
+	db := &DB{Name: path.Base(fpath)}
 
 	dbf, err := os.Open(fpath)
 	if err != nil {
 		return nil, err
 	}
-	tr := tar.NewReader(dbf)
+	defer dbf.Close()
 
-	if _, err := tr.Next(); err != nil { // the dir header, skip it
+	a, err := openArchive(dbf)
+	if err != nil {
 		return nil, err
 	}
-	if _, err := tr.Next(); err != nil { // the flib header
+	if db.manifest, err = a.manifest(); err != nil {
 		return nil, err
 	}
+	db.Params = db.manifest.Params
 
-	db.Lib, err = fragbag.Open(tr)
-	if err != nil {
+	if db.Lib, err = a.library(); err != nil {
 		return nil, err
 	}
+	db.fingerprint = fragbag.Fingerprint(db.Lib)
+	if err := db.manifest.checkLibrary(db.Lib); err != nil {
+		return nil, err
+	}
+
+	if mapped {
+		sec, ok := a.sections[fileFixed]
+		if !ok {
+			return nil, fmt.Errorf("BOW database '%s' does not have a fixed "+
+				"layout section.", fpath)
+		}
+		bs, unmap, err := mmap(dbf, sec.offset, sec.size)
+		if err != nil {
+			return nil, fmt.Errorf("Could not map '%s': %s", fileFixed, err)
+		}
+		if db.fixed, err = newFixedSection(bs, db.Lib.Size()); err != nil {
+			unmap()
+			return nil, err
+		}
+		db.fixed.unmap = unmap
+		return db, nil
+	}
 
-	if _, err := tr.Next(); err != nil { // the bow db header
+	if db.records, err = a.records(db.manifest); err != nil {
 		return nil, err
 	}
-	db.fileBuf = bufio.NewReaderSize(tr, 1<<20)
 	return db, nil
 }
 
 // ReadAll reads all entries from disk and returns them in a slice.
 // Subsequent calls do not read from disk; the already read entries are
-// returned.
+// returned. Entries are read exactly once, even if ReadAll is called from
+// multiple goroutines.
 //
-// ReadAll will panic if it is called on a database that was made with the
-// Create function.
+// ReadAll does not need to be called to search a database opened with
+// OpenMapped. If it is called, every entry is decoded from the fixed layout
+// section.
+//
+// ReadAll returns an error if it is called on a database that was made with
+// the Create function.
 func (db *DB) ReadAll() ([]bow.Bowed, error) {
-	if db.readAllLock == nil {
-		panic("DB.ReadAll cannot be called when the database is being written")
+	return db.ReadAllContext(context.Background(), nil)
+}
+
+// ReadAllContext is like ReadAll, except reading stops and the context's
+// error is returned if the context is cancelled. (A later call will start
+// reading again.) Progress is reported with the number of entries read and
+// the number of entries in the database, if it is known. The progress
+// function may be nil.
+func (db *DB) ReadAllContext(
+	ctx context.Context,
+	progress bow.Progress,
+) ([]bow.Bowed, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := db.readable(); err != nil {
+		return nil, err
+	}
+	return db.load(ctx, progress)
+}
+
+// readable returns an error if entries cannot be read from the database.
+// The caller must hold a read lock.
+func (db *DB) readable() error {
+	if db.closed {
+		return ErrClosed
 	}
+	if db.tw != nil {
+		return fmt.Errorf("Cannot read from BOW database '%s' while it is "+
+			"being written.", db)
+	}
+	return nil
+}
 
-	db.readAllLock.Lock()
-	defer db.readAllLock.Unlock()
+// load reads all entries into memory exactly once, unless it is cancelled.
+// The caller must hold a read lock.
+func (db *DB) load(
+	ctx context.Context,
+	progress bow.Progress,
+) ([]bow.Bowed, error) {
+	db.loadMu.Lock()
+	defer db.loadMu.Unlock()
 
-	if db.entries != nil {
-		return db.entries, nil
+	if db.loaded {
+		return db.entries, db.loadErr
 	}
-	db.entries = make([]bow.Bowed, 0, 10000)
+	entries, err := db.readEntries(ctx, progress)
+	if err != nil && err == ctx.Err() {
+		return nil, err
+	}
+	db.entries, db.loadErr, db.loaded = entries, err, true
+
+	// The undecoded entries are no longer needed.
+	db.records, db.fileBuf, db.entryBuf = nil, nil, nil
+	return db.entries, db.loadErr
+}
+
+// readEntries decodes every entry in the database. The context is checked
+// and progress is reported every progressInterval entries.
+func (db *DB) readEntries(
+	ctx context.Context,
+	progress bow.Progress,
+) ([]bow.Bowed, error) {
+	if db.fixed != nil {
+		n := db.fixed.len()
+		entries := make([]bow.Bowed, n)
+		for i := range entries {
+			if i%progressInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				progress.Report(i, n)
+			}
+			entry, err := db.fixed.bowed(i)
+			if err != nil {
+				return nil, err
+			}
+			entries[i] = entry
+		}
+		progress.Report(n, n)
+		return entries, nil
+	}
+
+	total := -1
+	if db.manifest.Version >= 2 {
+		total = db.manifest.Entries
+	}
+	db.fileBuf = bufio.NewReaderSize(bytes.NewReader(db.records), 1<<20)
+	entries := make([]bow.Bowed, 0, max(10000, db.manifest.Entries))
 	for {
+		if len(entries)%progressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			progress.Report(len(entries), total)
+		}
 		entry, err := db.read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
 		}
-		db.entries = append(db.entries, *entry)
+		entries = append(entries, *entry)
 	}
-	return db.entries, nil
+	progress.Report(len(entries), total)
+	return entries, nil
 }
 
 // Create creates a new BOW database on disk at 'dir'. If the directory
@@ -129,6 +308,19 @@ func (db *DB) ReadAll() ([]bow.Bowed, error) {
 // Once a BOW database is created, it cannot be modified. (This restriction
 // may be lifted in the future.)
 func Create(lib fragbag.Library, fpath string) (*DB, error) {
+	return CreateCodec(lib, fpath, nil)
+}
+
+// CreateCodec is like Create, except the BOWs in the database are compressed
+// with the codec given. If the codec is nil, the BOWs are not compressed.
+//
+// The name of the codec is recorded in the database's manifest, so that Open
+// can decompress it transparently.
+func CreateCodec(
+	lib fragbag.Library,
+	fpath string,
+	codec Codec,
+) (*DB, error) {
 	if _, err := os.Stat(fpath); err == nil || !os.IsNotExist(err) {
 		return nil, fmt.Errorf("BOW database '%s' already exists.", fpath)
 	}
@@ -138,9 +330,18 @@ func Create(lib fragbag.Library, fpath string) (*DB, error) {
 	}
 
 	db := &DB{
-		Lib:  lib,
-		Name: path.Base(fpath),
-
+		Lib:         lib,
+		Name:        path.Base(fpath),
+		fingerprint: fragbag.Fingerprint(lib),
+		manifest: Manifest{
+			Version:     FormatVersion,
+			Created:     time.Now(),
+			Fingerprint: fragbag.Fingerprint(lib),
+			Checksums:   make(map[string]string, 2),
+		},
+		codec: codec,
+
+		out:         outf,
 		tw:          tar.NewWriter(outf),
 		saveBuf:     new(bytes.Buffer),
 		writeBuf:    new(bytes.Buffer),
@@ -148,30 +349,43 @@ func Create(lib fragbag.Library, fpath string) (*DB, error) {
 		writingDone: make(chan struct{}),
 	}
 
+	fail := func(err error) (*DB, error) {
+		outf.Close()
+		os.Remove(fpath)
+		return nil, err
+	}
+
 	// Put all bow DB files in a directory within the archive.
 	hdrDir := db.newHdrDir(db.dirName())
 	if err := db.tw.WriteHeader(hdrDir); err != nil {
-		return nil, err
+		return fail(err)
 	}
 
 	// Create an entry for the fragment library. Copy the bytes.
 	flibBytes := new(bytes.Buffer)
 	if err := fragbag.Save(flibBytes, db.Lib); err != nil {
-		return nil, fmt.Errorf("Could not copy fragment library: %s", err)
+		return fail(fmt.Errorf("Could not copy fragment library: %s", err))
 	}
 	hdr := db.newHdr(fileFragLib, flibBytes.Len())
 	if err := db.tw.WriteHeader(hdr); err != nil {
-		return nil, err
+		return fail(err)
 	}
 	if _, err := db.tw.Write(flibBytes.Bytes()); err != nil {
-		return nil, err
+		return fail(err)
 	}
+	db.manifest.Checksums[fileFragLib] = checksum(flibBytes.Bytes())
 
 	// Now spin up a goroutine that is responsible for writing entries.
+	// Only the first error is kept, and it is returned by Close.
 	go func() {
 		for entry := range db.entryChan {
-			if err = db.write(entry); err != nil {
-				log.Printf("Could not write to %s: %s", fileBowDB, err)
+			if err := db.write(entry); err != nil {
+				if db.writeErr == nil {
+					db.writeErr = fmt.Errorf("Could not write '%s' to %s: %s",
+						entry.Id, fileBowDB, err)
+				}
+			} else {
+				db.count++
 			}
 		}
 		db.writingDone <- struct{}{}
@@ -181,37 +395,196 @@ func Create(lib fragbag.Library, fpath string) (*DB, error) {
 
 // Add will add a row to the database. It is safe to call `Add` from multiple
 // goroutines. The bowed value given must have been computed with the fragment
-// library given to Create.
+// library given to Create. If it wasn't, an error is returned.
 //
-// Add will panic if it is called on a BOW database that has been opened for
-// reading.
-func (db *DB) Add(e bow.Bowed) {
-	if db.entryChan == nil {
-		panic("Cannot add to a BOW database opened in read mode.")
+// Add returns an error if it is called on a BOW database that has been opened
+// for reading.
+func (db *DB) Add(e bow.Bowed) error {
+	return db.AddContext(context.Background(), e)
+}
+
+// AddContext is like Add, except it returns the context's error if the
+// context is cancelled before the entry could be added.
+func (db *DB) AddContext(ctx context.Context, e bow.Bowed) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.tw == nil {
+		return fmt.Errorf("Cannot add to BOW database '%s' since it was "+
+			"opened for reading.", db)
+	}
+	if err := db.compatible(e); err != nil {
+		return err
+	}
+	// A select picks randomly when both cases are ready, so check for
+	// cancellation first.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case db.entryChan <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	db.entryChan <- e
 }
 
-// Close should be called when done reading/writing a BOW db.
+// Manifest returns the manifest of this database. When the database is being
+// written, the manifest is not complete until Close is called.
+func (db *DB) Manifest() Manifest {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.manifest
+}
+
+// Fingerprint returns the fingerprint of the fragment library used to make
+// this database. (See fragbag.Fingerprint.)
+func (db *DB) Fingerprint() string {
+	return db.fingerprint
+}
+
+// compatible returns an error if the bowed value given could not have been
+// computed with this database's fragment library. Namely, the size of its
+// BOW must be the size of the library and, if the bowed value has a
+// fingerprint, it must match the fingerprint of the library.
+func (db *DB) compatible(e bow.Bowed) error {
+	if e.Bow.Len() != db.Lib.Size() {
+		return fmt.Errorf("The BOW for '%s' has size %d, but the fragment "+
+			"library (%s) of database '%s' has size %d.",
+			e.Id, e.Bow.Len(), db.Lib.Name(), db, db.Lib.Size())
+	}
+	if len(e.Fingerprint) > 0 && len(db.fingerprint) > 0 &&
+		e.Fingerprint != db.fingerprint {
+		return fmt.Errorf("The BOW for '%s' was computed with a fragment "+
+			"library (fingerprint %s) that differs from the fragment "+
+			"library (%s, fingerprint %s) of database '%s'. (Fingerprints "+
+			"include library names, so a renamed library differs too.)",
+			e.Id, e.Fingerprint, db.Lib.Name(), db.fingerprint, db)
+	}
+	return nil
+}
+
+// Close should be called when done reading/writing a BOW db. When writing,
+// every entry added is written to disk. If any entry could not be written,
+// Close returns the first such error and removes the incomplete database.
+// When reading, the file and memory used by the database are released. Close
+// waits for any searches or reads in progress to finish.
+//
+// Once closed, every method of the database returns ErrClosed, including
+// Close.
 func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	db.closed = true
+
 	if db.tw != nil {
-		close(db.entryChan)
-		<-db.writingDone
+		err := db.finish()
+		if cerr := db.out.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("Could not close bowdb archive: %s", cerr)
+		}
+		if err != nil {
+			os.Remove(db.out.Name())
+		}
+		db.tw, db.out, db.saveBuf, db.writeBuf = nil, nil, nil, nil
+		db.bowBuf = nil
+		return err
+	}
+
+	var err error
+	if db.fixed != nil {
+		err = db.fixed.close()
+		db.fixed = nil
+	}
+	db.entries, db.records, db.fileBuf, db.entryBuf = nil, nil, nil, nil
+	db.bowPool, db.dataPool = nil, nil
+	return err
+}
+
+// finish writes every entry added along with the manifest to the archive.
+func (db *DB) finish() error {
+	close(db.entryChan)
+	<-db.writingDone
+	if db.writeErr != nil {
+		return db.writeErr
+	}
+	db.manifest.Entries = db.count
+
+	records := db.saveBuf.Bytes()
+	if db.codec != nil {
+		compressed, err := db.compress(records)
+		if err != nil {
+			return fmt.Errorf("Could not compress bow db: %s", err)
+		}
+		records = compressed
+		db.manifest.Codec = db.codec.Name()
+	}
+
+	hdr := db.newHdr(fileBowDB, len(records))
+	if err := db.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("Could not write TAR header for bow db: %s", err)
+	}
+	if _, err := db.tw.Write(records); err != nil {
+		return fmt.Errorf("Could not write contents of bow db: %s", err)
+	}
+	db.manifest.Checksums[fileBowDB] = checksum(records)
 
-		hdr := db.newHdr(fileBowDB, db.saveBuf.Len())
+	if db.Mappable {
+		fixed, err := encodeFixed(db.saveBuf.Bytes(), db.Lib.Size())
+		if err != nil {
+			return fmt.Errorf("Could not encode %s: %s", fileFixed, err)
+		}
+		hdr = db.newHdr(fileFixed, len(fixed))
 		if err := db.tw.WriteHeader(hdr); err != nil {
-			return fmt.Errorf("Could not write TAR header for bow db: %s", err)
+			return fmt.Errorf("Could not write TAR header for %s: %s",
+				fileFixed, err)
 		}
-		if _, err := db.tw.Write(db.saveBuf.Bytes()); err != nil {
-			return fmt.Errorf("Could not write contents of bow db: %s", err)
+		if _, err := db.tw.Write(fixed); err != nil {
+			return fmt.Errorf("Could not write %s: %s", fileFixed, err)
 		}
+		db.manifest.Checksums[fileFixed] = checksum(fixed)
+	}
 
-		if err := db.tw.Close(); err != nil {
-			return fmt.Errorf("Could not close bowdb archive: %s", err)
-		}
+	db.manifest.Params = db.Params
+	manifest, err := json.MarshalIndent(db.manifest, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Could not encode manifest: %s", err)
+	}
+	hdr = db.newHdr(fileManifest, len(manifest))
+	if err := db.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("Could not write TAR header for manifest: %s",
+			err)
+	}
+	if _, err := db.tw.Write(manifest); err != nil {
+		return fmt.Errorf("Could not write manifest: %s", err)
+	}
+
+	if err := db.tw.Close(); err != nil {
+		return fmt.Errorf("Could not close bowdb archive: %s", err)
 	}
 	return nil
-	// return db.file.Close()
+}
+
+// compress returns the bytes given compressed with the database's codec.
+func (db *DB) compress(bs []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := db.codec.NewWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(bs); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // String returns the name of the database.
@@ -273,20 +646,35 @@ func (db *DB) read() (*bow.Bowed, error) {
 	data := db.newData(len(db.entryBuf))
 	copy(data, db.entryBuf)
 
+	// Read in the structured meta data.
+	var meta bow.Metadata
+	if db.manifest.hasMetadata() {
+		if err := db.readItem(); err != nil {
+			return nil, err
+		}
+		var err error
+		if meta, err = decodeMeta(db.entryBuf); err != nil {
+			return nil, fmt.Errorf("Could not read meta data of '%s': %s",
+				id, err)
+		}
+	}
+
 	// Now read in the BOW.
 	if err := db.readItem(); err != nil {
 		return nil, err
 	}
 
 	freqs := db.newBow()
-	// Advance 6 bytes at a time. 2 bytes for the fragment index and
-	// 4 bytes for the fragment frequency.
-	for i := 0; i < len(db.entryBuf); i += 6 {
-		fragi := binary.BigEndian.Uint16(db.entryBuf[i : i+2])
-		freqs[fragi] = math.Float32frombits(
-			binary.BigEndian.Uint32(db.entryBuf[i+2 : i+6]))
-	}
-	return &bow.Bowed{Id: id, Data: data, Bow: bow.Bow{freqs}}, nil
+	err := decodeBow(db.entryBuf, freqs, db.manifest.varintIndices())
+	if err != nil {
+		return nil, fmt.Errorf("Could not read BOW '%s': %s", id, err)
+	}
+	return &bow.Bowed{
+		Id:   id,
+		Data: data,
+		Meta: meta,
+		Bow:  bow.Bow{freqs},
+	}, nil
 }
 
 func (db *DB) newBow() []float32 {
@@ -348,46 +736,143 @@ func (db *DB) readNBytes(n int) error {
 	return nil
 }
 
+// write encodes a single entry as a record and appends it to the bow db.
+// The record is encoded completely before any of it is appended, so that an
+// entry that cannot be encoded does not corrupt the records around it.
 func (db *DB) write(entry bow.Bowed) error {
-	libSize := db.Lib.Size()
+	var meta []byte
+	if !entry.Meta.IsZero() {
+		var err error
+		if meta, err = json.Marshal(entry.Meta); err != nil {
+			return fmt.Errorf("Error writing meta data of '%s': %s",
+				entry.Id, err)
+		}
+	}
 
-	db.writeBuf.WriteString(entry.Id)
-	if err := db.writeItem(); err != nil {
-		return err
+	// Store BOWs as sparse frequency vectors. Each fragment index is
+	// stored as a varint encoded difference from the previous index.
+	var delta [binary.MaxVarintLen64]byte
+	var freq [4]byte
+	db.bowBuf = db.bowBuf[:0]
+	last := 0
+	for i, f := range entry.Bow.Freqs {
+		if f > 0 {
+			n := binary.PutUvarint(delta[:], uint64(i-last))
+			db.bowBuf = append(db.bowBuf, delta[:n]...)
+			last = i
+			binary.BigEndian.PutUint32(freq[:], math.Float32bits(f))
+			db.bowBuf = append(db.bowBuf, freq[:]...)
+		}
 	}
 
-	db.writeBuf.Write(entry.Data)
-	if err := db.writeItem(); err != nil {
-		return err
+	db.writeBuf.Reset()
+	for _, item := range [][]byte{
+		[]byte(entry.Id), entry.Data, meta, db.bowBuf,
+	} {
+		if uint64(len(item)) > math.MaxUint32 {
+			return fmt.Errorf("An item of '%s' has %d bytes, which is too "+
+				"large for a record.", entry.Id, len(item))
+		}
+		binw(db.writeBuf, uint32(len(item)))
+		db.writeBuf.Write(item)
+	}
+	if _, err := db.saveBuf.Write(db.writeBuf.Bytes()); err != nil {
+		return fmt.Errorf("Could not write record: %s", err)
 	}
+	return nil
+}
 
-	// Store BOWs as sparse frequency vectors.
-	for i := 0; i < libSize; i++ {
-		f := entry.Bow.Freqs[i]
-		if f > 0 {
-			if err := binw(db.writeBuf, uint16(i)); err != nil {
-				return fmt.Errorf("Error writing bow '%s': %s", entry.Id, err)
+// decodeBow decodes a sparse frequency vector into 'freqs', which must have
+// length equal to the size of the fragment library. When 'varint' is false,
+// the format used before version 4 is decoded: each fragment index is stored
+// as a 2 byte integer. Otherwise, each fragment index is stored as a varint
+// encoded difference from the previous index. In both formats, each index
+// is followed by a 4 byte frequency.
+func decodeBow(bs []byte, freqs []float32, varint bool) error {
+	fragi := 0
+	for len(bs) > 0 {
+		if !varint {
+			if len(bs) < 6 {
+				return fmt.Errorf("Truncated fragment frequency.")
+			}
+			fragi, bs = int(binary.BigEndian.Uint16(bs)), bs[2:]
+		} else {
+			delta, n := binary.Uvarint(bs)
+			if n <= 0 || len(bs) < n+4 {
+				return fmt.Errorf("Truncated fragment frequency.")
 			}
-			if err := binw(db.writeBuf, f); err != nil {
-				return fmt.Errorf("Error writing BOW '%s': %s", entry.Id, err)
+			if delta >= uint64(len(freqs)) {
+				return fmt.Errorf("Fragment index out of range.")
 			}
+			fragi, bs = fragi+int(delta), bs[n:]
 		}
-	}
-	if err := db.writeItem(); err != nil {
-		return err
+		if fragi >= len(freqs) {
+			return fmt.Errorf("Fragment %d is out of range for a fragment "+
+				"library with %d fragments.", fragi, len(freqs))
+		}
+		freqs[fragi] = math.Float32frombits(binary.BigEndian.Uint32(bs))
+		bs = bs[4:]
 	}
 	return nil
 }
 
-func (db *DB) writeItem() error {
-	itemLen := uint32(db.writeBuf.Len())
-	if err := binw(db.saveBuf, itemLen); err != nil {
-		return fmt.Errorf("Could not write item size: %s", err)
+// decodeMeta decodes the structured meta data of a record. An empty item
+// has no meta data.
+func decodeMeta(bs []byte) (bow.Metadata, error) {
+	var meta bow.Metadata
+	if len(bs) == 0 {
+		return meta, nil
 	}
-	if _, err := db.saveBuf.Write(db.writeBuf.Bytes()); err != nil {
-		return fmt.Errorf("Could not write item: %s", err)
+	err := json.Unmarshal(bs, &meta)
+	return meta, err
+}
+
+// eachRecord calls 'f' with the id, data, encoded meta data and frequencies
+// of every record in the bytes of a 'bow.db' file with the format described
+// by the manifest given. The frequencies passed to 'f' are reused for every
+// record. The meta data is empty for formats older than version 5.
+func eachRecord(
+	bs []byte,
+	libSize int,
+	m Manifest,
+	f func(id, data, meta []byte, freqs []float32) error,
+) error {
+	nitems := 3
+	if m.hasMetadata() {
+		nitems = 4
+	}
+	freqs := make([]float32, libSize)
+	for count := 0; len(bs) > 0; count++ {
+		var items [4][]byte
+		for i := 0; i < nitems; i++ {
+			if len(bs) < 4 {
+				return fmt.Errorf("Record %d is truncated.", count)
+			}
+			n := binary.BigEndian.Uint32(bs)
+			if uint64(n) > uint64(len(bs)-4) {
+				return fmt.Errorf("Record %d is truncated.", count)
+			}
+			items[i], bs = bs[4:4+n], bs[4+n:]
+		}
+
+		id, data, meta, b := items[0], items[1], items[2], items[2]
+		if m.hasMetadata() {
+			b = items[3]
+		} else {
+			meta = nil
+		}
+
+		for i := range freqs {
+			freqs[i] = 0
+		}
+		if err := decodeBow(b, freqs, m.varintIndices()); err != nil {
+			return fmt.Errorf("The BOW of record %d (%s) is invalid: %s",
+				count, id, err)
+		}
+		if err := f(id, data, meta, freqs); err != nil {
+			return err
+		}
 	}
-	db.writeBuf.Reset()
 	return nil
 }
 
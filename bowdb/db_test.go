@@ -0,0 +1,423 @@
+package bowdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	path "path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/structure"
+)
+
+func testLibrary(t *testing.T) fragbag.StructureLibrary {
+	frags := [][]structure.Coords{
+		{{0, 0, 0}, {3.8, 0, 0}, {7.6, 0, 0}},
+		{{0, 0, 0}, {3.8, 0, 0}, {3.8, 3.8, 0}},
+		{{0, 0, 0}, {3.8, 0, 0}, {5.5, 3.3, 0.5}},
+	}
+	lib, err := fragbag.NewStructureAtoms("test", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+// testEntries returns entries for the library given. Every entry has data
+// and meta data, and their BOWs are sparse so that both the first and last
+// fragments are skipped by some entry.
+func testEntries(lib fragbag.Library) []bow.Bowed {
+	freqs := [][]float32{{2, 0, 1}, {0, 3, 0}, {0.5, 0.25, 4}}
+	entries := make([]bow.Bowed, len(freqs))
+	for i, fs := range freqs {
+		b := bow.NewBow(lib.Size())
+		copy(b.Freqs, fs)
+		entries[i] = bow.Bowed{
+			Id:          string(rune('a' + i)),
+			Data:        []byte{byte(i), 'x'},
+			Meta:        bow.Metadata{Entry: "1ctf", Chain: "A", End: i + 1},
+			Fingerprint: fragbag.Fingerprint(lib),
+			Bow:         b,
+		}
+	}
+	return entries
+}
+
+// createDB writes the entries given to a new database in a temporary
+// directory and returns its path.
+func createDB(
+	t *testing.T,
+	lib fragbag.Library,
+	entries []bow.Bowed,
+	codec Codec,
+	mappable bool,
+) string {
+	fpath := path.Join(t.TempDir(), "test.bowdb")
+	db, err := CreateCodec(lib, fpath, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Mappable = mappable
+	for _, e := range entries {
+		if err := db.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fpath
+}
+
+// writeOldDB writes the entries given to a new database with the format
+// version given, as it was written before that version was superseded. The
+// meta data of entries is not written. Version 1 databases have no manifest.
+// If fixedVersion is not zero, a fixed layout section with that version is
+// added.
+func writeOldDB(
+	t *testing.T,
+	lib fragbag.Library,
+	entries []bow.Bowed,
+	version, fixedVersion int,
+) string {
+	flib := new(bytes.Buffer)
+	if err := fragbag.Save(flib, lib); err != nil {
+		t.Fatal(err)
+	}
+	records := new(bytes.Buffer)
+	item := func(bs []byte) {
+		binw(records, uint32(len(bs)))
+		records.Write(bs)
+	}
+	for _, e := range entries {
+		item([]byte(e.Id))
+		item(e.Data)
+		freqs, last := new(bytes.Buffer), 0
+		for i, f := range e.Bow.Freqs {
+			if f == 0 {
+				continue
+			}
+			if version >= 4 {
+				var delta [binary.MaxVarintLen64]byte
+				freqs.Write(delta[:binary.PutUvarint(delta[:],
+					uint64(i-last))])
+				last = i
+			} else {
+				binw(freqs, uint16(i))
+			}
+			binw(freqs, f)
+		}
+		item(freqs.Bytes())
+	}
+
+	files := []string{fileFragLib, fileBowDB}
+	contents := map[string][]byte{
+		fileFragLib: flib.Bytes(),
+		fileBowDB:   records.Bytes(),
+	}
+	if fixedVersion > 0 {
+		files = append(files, fileFixed)
+		contents[fileFixed] = encodeTestFixed(lib, entries, fixedVersion)
+	}
+	if version > 1 {
+		m := Manifest{
+			Version:     version,
+			Entries:     len(entries),
+			Created:     time.Now(),
+			Fingerprint: fragbag.Fingerprint(lib),
+			Checksums:   make(map[string]string),
+		}
+		for _, name := range files {
+			m.Checksums[name] = checksum(contents[name])
+		}
+		bs, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fileManifest)
+		contents[fileManifest] = bs
+	}
+
+	fpath := path.Join(t.TempDir(), "old.bowdb")
+	f, err := os.Create(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, name := range files {
+		hdr := &tar.Header{
+			Name: path.Join("old", name),
+			Mode: 0644,
+			Size: int64(len(contents[name])),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(contents[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fpath
+}
+
+// encodeTestFixed returns a fixed layout section with the version given for
+// the entries given. Version 1 sections have no meta data.
+func encodeTestFixed(
+	lib fragbag.Library,
+	entries []bow.Bowed,
+	version int,
+) []byte {
+	offsets, freqs, blob := new(bytes.Buffer), new(bytes.Buffer), []byte{}
+	binw(offsets, uint64(0))
+	for _, e := range entries {
+		blob = append(blob, e.Id...)
+		binw(offsets, uint64(len(blob)))
+		blob = append(blob, e.Data...)
+		binw(offsets, uint64(len(blob)))
+		if version > 1 {
+			meta, _ := json.Marshal(e.Meta)
+			blob = append(blob, meta...)
+			binw(offsets, uint64(len(blob)))
+		}
+		binw(freqs, e.Bow.Freqs)
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(fixedMagic)
+	binw(buf, uint32(version))
+	binw(buf, uint64(len(entries)))
+	binw(buf, uint64(lib.Size()))
+	buf.Write(offsets.Bytes())
+	buf.Write(freqs.Bytes())
+	buf.Write(blob)
+	return buf.Bytes()
+}
+
+// withoutMeta returns a copy of the entries given without meta data, as
+// they are read from databases older than version 5.
+func withoutMeta(entries []bow.Bowed) []bow.Bowed {
+	stripped := make([]bow.Bowed, len(entries))
+	for i, e := range entries {
+		e.Meta = bow.Metadata{}
+		stripped[i] = e
+	}
+	return stripped
+}
+
+// assertEntries fails the test if the entries read from a database differ
+// from the entries expected. Fingerprints are not stored in databases, so
+// they are not compared.
+func assertEntries(t *testing.T, name string, expected, got []bow.Bowed) {
+	if len(got) != len(expected) {
+		t.Fatalf("%s: Expected %d entries but got %d.",
+			name, len(expected), len(got))
+	}
+	for i, e := range expected {
+		g := got[i]
+		if g.Id != e.Id || !bytes.Equal(g.Data, e.Data) {
+			t.Fatalf("%s: Expected entry '%s' with data %v but got '%s' "+
+				"with data %v.", name, e.Id, e.Data, g.Id, g.Data)
+		}
+		if !reflect.DeepEqual(g.Meta, e.Meta) {
+			t.Fatalf("%s: Expected meta data %+v for '%s' but got %+v.",
+				name, e.Meta, e.Id, g.Meta)
+		}
+		if !reflect.DeepEqual(g.Bow.Freqs, e.Bow.Freqs) {
+			t.Fatalf("%s: Expected BOW %v for '%s' but got %v.",
+				name, e.Bow.Freqs, e.Id, g.Bow.Freqs)
+		}
+	}
+}
+
+// assertDB opens the database at the path given, with OpenMapped if
+// 'mapped' is true, and checks that it verifies, that it contains exactly
+// the entries expected and that searching for each entry finds it first.
+func assertDB(
+	t *testing.T,
+	name, fpath string,
+	mapped bool,
+	expected []bow.Bowed,
+) {
+	if err := Verify(fpath); err != nil {
+		t.Fatalf("%s: Could not verify database: %s", name, err)
+	}
+	open := Open
+	if mapped {
+		open = OpenMapped
+	}
+	db, err := open(fpath)
+	if err != nil {
+		t.Fatalf("%s: Could not open database: %s", name, err)
+	}
+	defer db.Close()
+
+	for _, query := range expected {
+		results, err := db.Search(SearchDefault, query)
+		if err != nil {
+			t.Fatalf("%s: Could not search for '%s': %s", name, query.Id, err)
+		}
+		if len(results) != len(expected) {
+			t.Fatalf("%s: Expected %d results for '%s' but got %d.",
+				name, len(expected), query.Id, len(results))
+		}
+		if results[0].Id != query.Id || results[0].Cosine > 1e-6 {
+			t.Fatalf("%s: Expected '%s' to be the closest entry to itself, "+
+				"but got '%s' with distance %f.",
+				name, query.Id, results[0].Id, results[0].Cosine)
+		}
+	}
+
+	all, err := db.ReadAll()
+	if err != nil {
+		t.Fatalf("%s: Could not read entries: %s", name, err)
+	}
+	assertEntries(t, name, expected, all)
+}
+
+func TestRoundTrip(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	tests := []struct {
+		name     string
+		codec    Codec
+		mappable bool
+	}{
+		{"plain", nil, false},
+		{"gzip", Gzip, false},
+		{"mappable", nil, true},
+		{"gzip mappable", Gzip, true},
+	}
+	for _, test := range tests {
+		fpath := createDB(t, lib, entries, test.codec, test.mappable)
+		assertDB(t, test.name, fpath, false, entries)
+		if test.mappable {
+			assertDB(t, test.name+" (mapped)", fpath, true, entries)
+		}
+
+		db, err := Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := db.Manifest()
+		db.Close()
+		if m.Version != FormatVersion || m.Entries != len(entries) {
+			t.Fatalf("%s: Expected manifest version %d with %d entries, "+
+				"but got version %d with %d entries.", test.name,
+				FormatVersion, len(entries), m.Version, m.Entries)
+		}
+		if test.codec != nil && m.Codec != test.codec.Name() {
+			t.Fatalf("%s: Expected codec '%s' but got '%s'.",
+				test.name, test.codec.Name(), m.Codec)
+		}
+	}
+}
+
+func TestOpenMappedWithoutFixed(t *testing.T) {
+	lib := testLibrary(t)
+	fpath := createDB(t, lib, testEntries(lib), nil, false)
+	if db, err := OpenMapped(fpath); err == nil {
+		db.Close()
+		t.Fatal("Expected OpenMapped to fail without a fixed layout section.")
+	}
+}
+
+func TestOldVersions(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	for version := 1; version < FormatVersion; version++ {
+		name := "version " + string(rune('0'+version))
+		fpath := writeOldDB(t, lib, entries, version, 0)
+		assertDB(t, name, fpath, false, withoutMeta(entries))
+	}
+
+	// Fixed layout sections with version 1 have no meta data, even if
+	// the records do.
+	fpath := writeOldDB(t, lib, entries, 4, 1)
+	assertDB(t, "fixed version 1", fpath, true, withoutMeta(entries))
+}
+
+func TestNewerVersion(t *testing.T) {
+	lib := testLibrary(t)
+	fpath := writeOldDB(t, lib, testEntries(lib), FormatVersion+1, 0)
+	if db, err := Open(fpath); err == nil {
+		db.Close()
+		t.Fatal("Expected a database with a newer version to fail to open.")
+	}
+}
+
+func TestCloseWriteError(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+
+	// Meta data with a NaN coverage cannot be encoded as JSON.
+	nan := math.NaN()
+	bad := entries[1]
+	bad.Id = "bad"
+	bad.Meta.Coverage = &nan
+
+	fpath := path.Join(t.TempDir(), "test.bowdb")
+	db, err := Create(lib, fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []bow.Bowed{entries[0], bad, entries[2]} {
+		if err := db.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err == nil {
+		t.Fatal("Expected an error closing a database with a bad entry.")
+	}
+	if _, err := os.Stat(fpath); !os.IsNotExist(err) {
+		t.Fatalf("Expected the incomplete database to be removed, but got "+
+			"%v.", err)
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	lib := testLibrary(t)
+	fpath := createDB(t, lib, testEntries(lib), nil, false)
+	if _, err := Create(lib, fpath); err == nil {
+		t.Fatal("Expected an error creating a database that exists.")
+	}
+	if err := Verify(fpath); err != nil {
+		t.Fatalf("Expected the existing database to be intact: %s", err)
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	coverage, zero := 0.75, 0.0
+	entries[0].Meta = bow.Metadata{
+		Source:     "/pdb/1ctf.pdb",
+		Entry:      "1ctf",
+		Chain:      "A",
+		Model:      2,
+		Start:      -3,
+		End:        68,
+		Sequence:   "MK",
+		Resolution: 1.7,
+		Scop:       "d.45.1.1",
+		Cath:       "3.30.1390.10",
+		Coverage:   &coverage,
+		Extra:      map[string]string{"segments": "A:1-68"},
+	}
+	entries[1].Meta = bow.Metadata{Coverage: &zero}
+	entries[2].Meta = bow.Metadata{}
+
+	fpath := createDB(t, lib, entries, nil, true)
+	assertDB(t, "meta data", fpath, false, entries)
+	assertDB(t, "meta data (mapped)", fpath, true, entries)
+}
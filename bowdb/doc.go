@@ -12,5 +12,29 @@ Every BOW database is associated with one and only one fragment library. When a
 BOW database is saved, a copy of the fragment library is embedded into the
 database. This library---and only this library---should be used to compute
 Bowed values for use with the Search function.
+
+A BOW database is stored as a tar archive containing the fragment library, a
+file of BOWs and a manifest. The manifest records the version of the format,
+the number of entries, when and how the database was created, the fingerprint
+of its fragment library and a checksum of every other file in the archive. The
+Verify function uses the manifest to detect corrupted databases.
+
+Since version 4 of the format, the fragment indices of each BOW are stored as
+varint encoded differences, so that fragment libraries of any size can be
+used. Older databases, which store fragment indices as 2 byte integers, can
+still be read.
+
+Since version 5 of the format, the structured meta data of each entry (see
+bow.Metadata) is stored along with it and returned in search results. Entries
+read from older databases have no meta data.
+
+The BOWs in a database may be compressed with a Codec (see CreateCodec). The
+codec is recorded in the manifest, so that Open decompresses the database
+transparently.
+
+Databases can also be created with a fixed layout section (see DB.Mappable),
+which stores every BOW as a dense vector. Such a database can be opened with
+OpenMapped, which memory maps the section and searches it in place instead of
+loading every entry into memory.
 */
 package bowdb
@@ -0,0 +1,105 @@
+package bowdb
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag/bow"
+)
+
+// Filter is a predicate that determines whether an entry in a database may
+// be included in the results of a search with the query given. Filters are
+// given with the Filters field of SearchOptions.
+//
+// Filters are only called for entries that are close enough to the query to
+// be included in the results, so that entries that are filtered out don't
+// need to be read in full.
+type Filter func(query, entry bow.Bowed) bool
+
+// filtered returns true if any of the filters given excludes the entry.
+func filtered(filters []Filter, query, entry bow.Bowed) bool {
+	for _, f := range filters {
+		if !f(query, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Not returns a filter that includes exactly the entries excluded by the
+// filter given.
+func Not(f Filter) Filter {
+	return func(query, entry bow.Bowed) bool {
+		return !f(query, entry)
+	}
+}
+
+// IdPrefix returns a filter that includes entries whose id starts with any
+// of the prefixes given.
+func IdPrefix(prefixes ...string) Filter {
+	return func(query, entry bow.Bowed) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(entry.Id, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// IdRegexp returns a filter that includes entries whose id matches the
+// regular expression given.
+func IdRegexp(re *regexp.Regexp) Filter {
+	return func(query, entry bow.Bowed) bool {
+		return re.MatchString(entry.Id)
+	}
+}
+
+// MetaEquals returns a filter that includes entries whose meta data has any
+// of the values given for the key given. (See bow.Metadata.Get for how keys
+// are matched.)
+func MetaEquals(key string, values ...string) Filter {
+	return func(query, entry bow.Bowed) bool {
+		v, ok := entry.Meta.Get(key)
+		if !ok {
+			return false
+		}
+		for _, value := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ExcludeQuery is a filter that excludes entries with the same id as the
+// query.
+func ExcludeQuery(query, entry bow.Bowed) bool {
+	return query.Id != entry.Id
+}
+
+// ExcludeSameEntry is a filter that excludes entries from the same PDB entry
+// as the query. This is useful for benchmarks, where hits from the same PDB
+// entry are trivially similar to the query.
+//
+// The PDB entry of an id is recognized in chain ids (e.g., "1ctfA"), SCOP
+// domain ids (e.g., "d1ctfa_") and CATH domain ids (e.g., "1ctfA00"). Other
+// ids are compared as is.
+func ExcludeSameEntry(query, entry bow.Bowed) bool {
+	return pdbEntry(query.Id) != pdbEntry(entry.Id)
+}
+
+// pdbEntry returns the lower case PDB entry identifier of the id given. If
+// the id does not contain one, it is returned unchanged.
+func pdbEntry(id string) string {
+	isDigit := func(b byte) bool { return b >= '0' && b <= '9' }
+	switch {
+	case len(id) == 7 && strings.IndexByte("deg", id[0]) > -1 &&
+		isDigit(id[1]):
+		return strings.ToLower(id[1:5])
+	case len(id) >= 4 && isDigit(id[0]):
+		return strings.ToLower(id[0:4])
+	}
+	return id
+}
@@ -0,0 +1,94 @@
+package bowdb
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag/bow"
+)
+
+func TestPdbEntry(t *testing.T) {
+	tests := []struct {
+		id, expected string
+	}{
+		{"1ctfA", "1ctf"},
+		{"1CTF", "1ctf"},
+		{"1ctf", "1ctf"},
+		{"d1ctfa_", "1ctf"},
+		{"g1cuka1", "1cuk"},
+		{"e1a0qH1", "1a0q"},
+		{"1cukA01", "1cuk"},
+		{"101mA00", "101m"},
+		{"dxctfa_", "dxctfa_"},
+		{"d1ctf", "d1ctf"},
+		{"abc", "abc"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := pdbEntry(test.id); got != test.expected {
+			t.Fatalf("Expected PDB entry '%s' for '%s' but got '%s'.",
+				test.expected, test.id, got)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	coverage := 0.5
+	entry := func(id string, meta bow.Metadata) bow.Bowed {
+		return bow.Bowed{Id: id, Meta: meta}
+	}
+	query := entry("1ctfA", bow.Metadata{})
+	tests := []struct {
+		name     string
+		filter   Filter
+		entry    bow.Bowed
+		expected bool
+	}{
+		{"prefix", IdPrefix("1c", "2a"), entry("1ctfB", bow.Metadata{}),
+			true},
+		{"second prefix", IdPrefix("1c", "2a"),
+			entry("2abc", bow.Metadata{}), true},
+		{"no prefix", IdPrefix("1c", "2a"), entry("3abc", bow.Metadata{}),
+			false},
+		{"no prefixes", IdPrefix(), entry("1ctfB", bow.Metadata{}), false},
+		{"not prefix", Not(IdPrefix("1c")), entry("1ctfB", bow.Metadata{}),
+			false},
+		{"regexp", IdRegexp(regexp.MustCompile("^d.*_$")),
+			entry("d1ctfa_", bow.Metadata{}), true},
+		{"no regexp", IdRegexp(regexp.MustCompile("^d.*_$")),
+			entry("d1cuka1", bow.Metadata{}), false},
+		{"meta", MetaEquals("chain", "B", "A"),
+			entry("1ctfA", bow.Metadata{Chain: "A"}), true},
+		{"other meta", MetaEquals("chain", "B"),
+			entry("1ctfA", bow.Metadata{Chain: "A"}), false},
+		{"no meta", MetaEquals("chain", "A"), entry("1ctfA", bow.Metadata{}),
+			false},
+		{"meta coverage", MetaEquals("coverage", "0.5"),
+			entry("1ctfA", bow.Metadata{Coverage: &coverage}), true},
+		{"query", ExcludeQuery, entry("1ctfA", bow.Metadata{}), false},
+		{"other than query", ExcludeQuery, entry("1ctfB", bow.Metadata{}),
+			true},
+		{"same entry", ExcludeSameEntry, entry("d1ctfa_", bow.Metadata{}),
+			false},
+		{"other entry", ExcludeSameEntry, entry("d1cuka1", bow.Metadata{}),
+			true},
+	}
+	for _, test := range tests {
+		if got := test.filter(query, test.entry); got != test.expected {
+			t.Fatalf("%s: Expected %v for '%s' but got %v.",
+				test.name, test.expected, test.entry.Id, got)
+		}
+	}
+
+	include, exclude := IdPrefix("1"), Not(ExcludeQuery)
+	if !filtered([]Filter{include, exclude}, query, entry("1ctfB",
+		bow.Metadata{})) {
+		t.Fatal("Expected an entry excluded by one filter to be filtered.")
+	}
+	if filtered([]Filter{include}, query, entry("1ctfB", bow.Metadata{})) {
+		t.Fatal("Expected an entry included by every filter to be kept.")
+	}
+	if filtered(nil, query, entry("1ctfB", bow.Metadata{})) {
+		t.Fatal("Expected no filters to keep every entry.")
+	}
+}
@@ -0,0 +1,235 @@
+package bowdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/TuftsBCB/fragbag/bow"
+)
+
+// The fixed layout section of a BOW database stores every BOW as a dense
+// vector, so that it can be searched in place without decoding. Its layout
+// is as follows, where all integers and frequencies are big endian:
+//
+//	magic   [4]byte             "FBFX"
+//	version uint32
+//	count   uint64              the number of entries
+//	libSize uint64              the number of fragments in each BOW
+//	offsets [3*count+1]uint64   offsets into blob; entry i has its id at
+//	                            blob[offsets[3i]:offsets[3i+1]], its data
+//	                            at blob[offsets[3i+1]:offsets[3i+2]] and its
+//	                            JSON encoded meta data at
+//	                            blob[offsets[3i+2]:offsets[3i+3]]
+//	freqs   [count*libSize]float32
+//	blob    []byte
+//
+// Version 1 of the layout has no meta data, so it has only 2 offsets for
+// every entry.
+//
+// Offsets and frequencies are decoded on every access (with
+// binary.BigEndian and math.Float32frombits), so the section does not need to
+// be aligned in memory.
+const (
+	fixedMagic      = "FBFX"
+	fixedVersion    = 2
+	fixedHeaderSize = 24
+)
+
+// fixedSection provides access to the contents of a fixed layout section,
+// which are usually memory mapped.
+type fixedSection struct {
+	count, libSize int
+	stride         int // the number of offsets for every entry
+	offsets        []byte
+	freqs          []byte
+	blob           []byte
+
+	// unmap releases the memory of the section. It may be nil.
+	unmap func() error
+}
+
+// newFixedSection checks the layout of the section given and provides access
+// to it. The size of each BOW must be equal to the library size given.
+func newFixedSection(bs []byte, libSize int) (*fixedSection, error) {
+	if len(bs) < fixedHeaderSize || string(bs[0:4]) != fixedMagic {
+		return nil, fmt.Errorf("'%s' is not a fixed layout section.",
+			fileFixed)
+	}
+	stride := uint64(3)
+	switch v := binary.BigEndian.Uint32(bs[4:8]); v {
+	case 1:
+		stride = 2
+	case fixedVersion:
+	default:
+		return nil, fmt.Errorf("Unsupported version %d of '%s'.",
+			v, fileFixed)
+	}
+	count := binary.BigEndian.Uint64(bs[8:16])
+	size := binary.BigEndian.Uint64(bs[16:24])
+	if size != uint64(libSize) {
+		return nil, fmt.Errorf("The BOWs in '%s' have size %d, but the "+
+			"fragment library has size %d.", fileFixed, size, libSize)
+	}
+
+	// Every entry needs at least 8 bytes for each of its offsets, which
+	// also guards against overflow below.
+	rest := uint64(len(bs) - fixedHeaderSize)
+	if count > rest/(8*stride) {
+		return nil, fmt.Errorf("'%s' is truncated.", fileFixed)
+	}
+	offsetsLen, freqsLen := 8*(stride*count+1), 4*count*size
+	if offsetsLen+freqsLen > rest {
+		return nil, fmt.Errorf("'%s' is truncated.", fileFixed)
+	}
+
+	s := &fixedSection{
+		count:   int(count),
+		libSize: libSize,
+		stride:  int(stride),
+		offsets: bs[fixedHeaderSize : fixedHeaderSize+offsetsLen],
+	}
+	s.freqs = bs[fixedHeaderSize+offsetsLen:][:freqsLen]
+	s.blob = bs[fixedHeaderSize+offsetsLen+freqsLen:]
+
+	last := uint64(0)
+	for i := 0; i < s.stride*s.count+1; i++ {
+		off := s.offset(i)
+		if off < last || off > uint64(len(s.blob)) {
+			return nil, fmt.Errorf("'%s' has an invalid offset for "+
+				"entry %d.", fileFixed, i/s.stride)
+		}
+		last = off
+	}
+	return s, nil
+}
+
+// encodeFixed returns a fixed layout section for the records given (in the
+// format of a 'bow.db' file written by this package).
+func encodeFixed(records []byte, libSize int) ([]byte, error) {
+	offsets, freqs, blob := new(bytes.Buffer), new(bytes.Buffer), []byte{}
+	count := 0
+	binw(offsets, uint64(0))
+	err := eachRecord(records, libSize, Manifest{Version: FormatVersion},
+		func(id, data, meta []byte, fs []float32) error {
+			blob = append(blob, id...)
+			binw(offsets, uint64(len(blob)))
+			blob = append(blob, data...)
+			binw(offsets, uint64(len(blob)))
+			blob = append(blob, meta...)
+			binw(offsets, uint64(len(blob)))
+			count++
+			return binw(freqs, fs)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(fixedMagic)
+	binw(buf, uint32(fixedVersion))
+	binw(buf, uint64(count))
+	binw(buf, uint64(libSize))
+	buf.Write(offsets.Bytes())
+	buf.Write(freqs.Bytes())
+	buf.Write(blob)
+	return buf.Bytes(), nil
+}
+
+// len returns the number of entries in the section.
+func (s *fixedSection) len() int {
+	return s.count
+}
+
+func (s *fixedSection) offset(i int) uint64 {
+	return binary.BigEndian.Uint64(s.offsets[8*i:])
+}
+
+// id returns the bytes of the id of entry i.
+func (s *fixedSection) id(i int) []byte {
+	return s.blob[s.offset(s.stride*i):s.offset(s.stride*i+1)]
+}
+
+// row returns the bytes of the frequencies of entry i.
+func (s *fixedSection) row(i int) []byte {
+	return s.freqs[4*i*s.libSize : 4*(i+1)*s.libSize]
+}
+
+// bowed decodes entry i. The memory of the value returned is not shared with
+// the section.
+func (s *fixedSection) bowed(i int) (bow.Bowed, error) {
+	first := s.stride * i
+	idStart, dataStart := s.offset(first), s.offset(first+1)
+	dataEnd := s.offset(first + 2)
+	var data []byte
+	if dataEnd > dataStart {
+		data = make([]byte, dataEnd-dataStart)
+		copy(data, s.blob[dataStart:dataEnd])
+	}
+
+	var meta bow.Metadata
+	if s.stride > 2 {
+		var err error
+		meta, err = decodeMeta(s.blob[dataEnd:s.offset(first+3)])
+		if err != nil {
+			return bow.Bowed{}, fmt.Errorf("Could not read meta data of "+
+				"'%s': %s", s.blob[idStart:dataStart], err)
+		}
+	}
+
+	b := bow.NewBow(s.libSize)
+	row := s.row(i)
+	for j := range b.Freqs {
+		b.Freqs[j] = math.Float32frombits(binary.BigEndian.Uint32(row[4*j:]))
+	}
+	return bow.Bowed{
+		Id:   string(s.blob[idStart:dataStart]),
+		Data: data,
+		Meta: meta,
+		Bow:  b,
+	}, nil
+}
+
+// cosine returns the cosine distance between entry i and the BOW given.
+// It is computed in exactly the same way as bow.Bow.Cosine.
+func (s *fixedSection) cosine(i int, b bow.Bow) float64 {
+	var dot, mag1, mag2 float32
+	row := s.row(i)
+
+	var f1, f2 float32
+	for j, f := range b.Freqs {
+		f1 = f
+		f2 = math.Float32frombits(binary.BigEndian.Uint32(row[4*j:]))
+		dot += f1 * f2
+		mag1 += f1 * f1
+		mag2 += f2 * f2
+	}
+	r := 1.0 - (float64(dot) / math.Sqrt(float64(mag1)*float64(mag2)))
+	if math.IsNaN(r) {
+		return 1.0
+	}
+	return r
+}
+
+// euclid returns the euclidean distance between entry i and the BOW given.
+// It is computed in exactly the same way as bow.Bow.Euclid.
+func (s *fixedSection) euclid(i int, b bow.Bow) float64 {
+	squareSum := float32(0)
+	row := s.row(i)
+	for j, f1 := range b.Freqs {
+		f2 := math.Float32frombits(binary.BigEndian.Uint32(row[4*j:]))
+		squareSum += (f2 - f1) * (f2 - f1)
+	}
+	return math.Sqrt(float64(squareSum))
+}
+
+// close releases the memory of the section.
+func (s *fixedSection) close() error {
+	if s.unmap == nil {
+		return nil
+	}
+	err := s.unmap()
+	s.unmap = nil
+	return err
+}
@@ -0,0 +1,104 @@
+package bowdb
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag/bow"
+)
+
+func TestMappedSearch(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	fpath := createDB(t, lib, entries, nil, true)
+
+	db, err := Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mdb, err := OpenMapped(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close()
+
+	euclidDesc := SearchDefault
+	euclidDesc.SortBy, euclidDesc.Order = SortByEuclid, OrderDesc
+	limited := SearchDefault
+	limited.Limit = 2
+	near := SearchClose
+	near.Max = 0.5
+
+	other := bow.NewBow(lib.Size())
+	copy(other.Freqs, []float32{1, 1, 0.5})
+	queries := append([]bow.Bowed{{Id: "q", Bow: other}}, entries...)
+	for _, opts := range []SearchOptions{
+		SearchDefault, euclidDesc, limited, near,
+	} {
+		for _, query := range queries {
+			expected, err := db.Search(opts, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := mdb.Search(opts, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("Expected mapped results %+v for '%s' (%+v), but "+
+					"got %+v.", expected, query.Id, opts, got)
+			}
+		}
+	}
+}
+
+func TestFixedSectionCorrupt(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(lib)
+	valid := encodeTestFixed(lib, entries, fixedVersion)
+	if _, err := newFixedSection(valid, lib.Size()); err != nil {
+		t.Fatalf("Could not read a valid fixed layout section: %s", err)
+	}
+
+	// edit returns a copy of the valid section changed by 'f'.
+	edit := func(f func(bs []byte)) []byte {
+		bs := append([]byte{}, valid...)
+		f(bs)
+		return bs
+	}
+	// The first offset of the second entry is at this position.
+	second := fixedHeaderSize + 8*3
+	tests := []struct {
+		name    string
+		bs      []byte
+		libSize int
+	}{
+		{"empty", nil, lib.Size()},
+		{"header only", valid[:fixedHeaderSize], lib.Size()},
+		{"truncated offsets", valid[:fixedHeaderSize+8], lib.Size()},
+		{"truncated frequencies", valid[:second+8*7], lib.Size()},
+		{"truncated blob", valid[:len(valid)-1], lib.Size()},
+		{"bad magic", edit(func(bs []byte) { bs[0] = 'X' }), lib.Size()},
+		{"bad version", edit(func(bs []byte) {
+			binary.BigEndian.PutUint32(bs[4:], 3)
+		}), lib.Size()},
+		{"huge count", edit(func(bs []byte) {
+			binary.BigEndian.PutUint64(bs[8:], 1<<62)
+		}), lib.Size()},
+		{"library size", valid, lib.Size() + 1},
+		{"decreasing offset", edit(func(bs []byte) {
+			binary.BigEndian.PutUint64(bs[second:], 0)
+		}), lib.Size()},
+		{"offset past blob", edit(func(bs []byte) {
+			binary.BigEndian.PutUint64(bs[second:], 1<<40)
+		}), lib.Size()},
+	}
+	for _, test := range tests {
+		if _, err := newFixedSection(test.bs, test.libSize); err == nil {
+			t.Fatalf("%s: Expected an error reading the fixed layout "+
+				"section.", test.name)
+		}
+	}
+}
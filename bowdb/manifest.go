@@ -0,0 +1,182 @@
+package bowdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/TuftsBCB/fragbag"
+)
+
+// FormatVersion is the version of the BOW database format written by this
+// package. Databases without a manifest (which were written before the
+// manifest was introduced) have version 1.
+const FormatVersion = 5
+
+// maxFragmentsUint16 is the number of fragments that can be indexed in
+// databases with a version older than 4, where fragment indices are stored
+// as 2 byte integers.
+const maxFragmentsUint16 = 1 << 16
+
+// Manifest describes the contents of a BOW database. It is stored in the
+// database archive as a JSON encoded file.
+type Manifest struct {
+	// The version of the database format.
+	Version int
+
+	// The number of entries in the database.
+	Entries int
+
+	// The time at which the database was created.
+	Created time.Time
+
+	// Arbitrary parameters recorded by the creator of the database, like
+	// the command used to make it.
+	Params map[string]string `json:",omitempty"`
+
+	// The fingerprint of the fragment library used to make the database.
+	Fingerprint string
+
+	// The name of the codec used to compress the BOWs in the database. If
+	// empty, the BOWs are not compressed. (Since version 3.)
+	Codec string `json:",omitempty"`
+
+	// A hex encoded SHA-256 checksum of every other file in the database,
+	// keyed by file name.
+	Checksums map[string]string
+}
+
+// varintIndices returns true if fragment indices in the database are stored
+// as varint encoded differences (since version 4).
+func (m Manifest) varintIndices() bool {
+	return m.Version >= 4
+}
+
+// hasMetadata returns true if records in the database store structured meta
+// data (since version 5).
+func (m Manifest) hasMetadata() bool {
+	return m.Version >= 5
+}
+
+// checkLibrary returns an error if the fragment library given does not have
+// the fingerprint recorded in the manifest, or if it has too many fragments
+// to be indexed by the format of the database.
+func (m Manifest) checkLibrary(lib fragbag.Library) error {
+	fp := fragbag.Fingerprint(lib)
+	if len(m.Fingerprint) > 0 && m.Fingerprint != fp {
+		return fmt.Errorf("The fragment library has fingerprint '%s', but "+
+			"the manifest records fingerprint '%s'. (Fingerprints include "+
+			"library names, so a renamed library differs too.)",
+			fp, m.Fingerprint)
+	}
+	if !m.varintIndices() && lib.Size() > maxFragmentsUint16 {
+		return fmt.Errorf("The fragment library has %d fragments, but BOW "+
+			"databases with format version %d can only index %d fragments. "+
+			"The database must be rebuilt.",
+			lib.Size(), m.Version, maxFragmentsUint16)
+	}
+	return nil
+}
+
+// checksum returns a hex encoded SHA-256 checksum of the bytes given.
+func checksum(bs []byte) string {
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks the integrity of the BOW database at the path given. In
+// particular, the checksums and entry count in its manifest are validated,
+// along with the framing of every record in the database and the layout of
+// its fixed layout section (if it has one).
+//
+// Databases written before manifests were introduced can still be verified,
+// but only the framing of their records is checked.
+func Verify(fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	a, err := openArchive(f)
+	if err != nil {
+		return err
+	}
+	m, err := a.manifest()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(m.Checksums))
+	for name := range m.Checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bs, err := a.read(name)
+		if err != nil {
+			return err
+		}
+		if sum := checksum(bs); sum != m.Checksums[name] {
+			return fmt.Errorf("Checksum mismatch for '%s': expected %s "+
+				"but got %s.", name, m.Checksums[name], sum)
+		}
+	}
+	if m.Version >= 2 {
+		for _, name := range []string{fileFragLib, fileBowDB} {
+			if _, ok := m.Checksums[name]; !ok {
+				return fmt.Errorf("The manifest has no checksum for '%s'.",
+					name)
+			}
+		}
+	}
+
+	lib, err := a.library()
+	if err != nil {
+		return err
+	}
+	if err := m.checkLibrary(lib); err != nil {
+		return err
+	}
+
+	records, err := a.records(m)
+	if err != nil {
+		return err
+	}
+	count := 0
+	err = eachRecord(records, lib.Size(), m,
+		func(id, data, meta []byte, freqs []float32) error {
+			count++
+			if _, err := decodeMeta(meta); err != nil {
+				return fmt.Errorf("The meta data of record %d (%s) is "+
+					"invalid: %s", count-1, id, err)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	if m.Version >= 2 && count != m.Entries {
+		return fmt.Errorf("Expected %d entries but found %d.",
+			m.Entries, count)
+	}
+
+	if a.has(fileFixed) {
+		bs, err := a.read(fileFixed)
+		if err != nil {
+			return err
+		}
+		fixed, err := newFixedSection(bs, lib.Size())
+		if err != nil {
+			return err
+		}
+		if fixed.len() != count {
+			return fmt.Errorf("Expected %d entries in '%s' but found %d.",
+				count, fileFixed, fixed.len())
+		}
+	}
+	return nil
+}
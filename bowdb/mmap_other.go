@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package bowdb
+
+import (
+	"os"
+)
+
+// mmap reads 'size' bytes of the file given starting at 'offset' into
+// memory, since memory mapping is not supported on this platform. The
+// function returned does nothing.
+func mmap(f *os.File, offset, size int64) ([]byte, func() error, error) {
+	bs := make([]byte, size)
+	if _, err := f.ReadAt(bs, offset); err != nil {
+		return nil, nil, err
+	}
+	return bs, func() error { return nil }, nil
+}
@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package bowdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps 'size' bytes of the file given starting at 'offset' into memory
+// as read only. The function returned unmaps the memory.
+func mmap(f *os.File, offset, size int64) ([]byte, func() error, error) {
+	pageOffset := offset &^ int64(os.Getpagesize()-1)
+	bs, err := syscall.Mmap(int(f.Fd()), pageOffset,
+		int(offset-pageOffset+size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	unmap := func() error { return syscall.Munmap(bs) }
+	return bs[offset-pageOffset:], unmap, nil
+}
@@ -1,9 +1,11 @@
 package bowdb
 
 import (
+	"context"
 	"fmt"
 	"math"
 
+	"github.com/TuftsBCB/fragbag"
 	"github.com/TuftsBCB/fragbag/bow"
 )
 
@@ -37,6 +39,10 @@ type SearchOptions struct {
 	// Order specifies whether the results are returned in ascending (OrderAsc)
 	// or descending (OrderDesc) order.
 	Order int
+
+	// Filters restricts results to entries that are included by every
+	// filter. It may be empty, in which case no entries are excluded.
+	Filters []Filter
 }
 
 // SearchDefault provides default search settings. Namely, it restricts the
@@ -78,30 +84,99 @@ func newSearchResult(query, entry bow.Bowed) SearchResult {
 
 // Search performs an exhaustive search against the query entry. The best N
 // results are returned with respect to the options given. The query given
-// must have been computed with this database's fragment library.
+// must have been computed with this database's fragment library. If it
+// wasn't, an error is returned. (See Add for the conditions checked.)
 //
 // Note that if the ReadAll method hasn't been called before, Search will
-// call it for you. (This means that the first search could take longer than
-// one would otherwise expect.)
+// read all entries for you. (This means that the first search could take
+// longer than one would otherwise expect.) Databases opened with OpenMapped
+// are searched in place, so entries are never read into memory.
 //
 // It is safe to call Search on the same database from multiple goroutines.
-func (db *DB) Search(opts SearchOptions, query bow.Bowed) []SearchResult {
-	tree := new(bst)
+func (db *DB) Search(
+	opts SearchOptions,
+	query bow.Bowed,
+) ([]SearchResult, error) {
+	return db.SearchContext(context.Background(), opts, query, nil)
+}
+
+// SearchContext is like Search, except the search stops and the context's
+// error is returned if the context is cancelled. Progress is reported with
+// the number of entries compared with the query and the number of entries in
+// the database. The progress function may be nil.
+//
+// If entries need to be read before searching, progress is not reported
+// while reading. Use ReadAllContext beforehand to report it.
+func (db *DB) SearchContext(
+	ctx context.Context,
+	opts SearchOptions,
+	query bow.Bowed,
+	progress bow.Progress,
+) ([]SearchResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	if db.entries == nil {
-		db.ReadAll()
+	if err := db.readable(); err != nil {
+		return nil, err
 	}
-	for _, entry := range db.entries {
-		// Compute the distance between the query and the target.
-		var dist float64
-		switch opts.SortBy {
-		case SortByCosine:
-			dist = query.Bow.Cosine(entry.Bow)
-		case SortByEuclid:
-			dist = query.Bow.Euclid(entry.Bow)
-		default:
-			panic(fmt.Sprintf("Unrecognized SortBy value: %d", opts.SortBy))
+	if err := db.compatible(query); err != nil {
+		return nil, err
+	}
+	if opts.SortBy != SortByCosine && opts.SortBy != SortByEuclid {
+		panic(fmt.Sprintf("Unrecognized SortBy value: %d", opts.SortBy))
+	}
+
+	if db.fixed != nil {
+		fixed := db.fixed
+		dist := fixed.euclid
+		if opts.SortBy == SortByCosine {
+			dist = fixed.cosine
 		}
+		return search(ctx, progress, opts, query, fixed.len(),
+			func(i int) float64 { return dist(i, query.Bow) },
+			fixed.bowed)
+	}
+
+	entries, err := db.load(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	dist := query.Bow.Euclid
+	if opts.SortBy == SortByCosine {
+		dist = query.Bow.Cosine
+	}
+	return search(ctx, progress, opts, query, len(entries),
+		func(i int) float64 { return dist(entries[i].Bow) },
+		func(i int) (bow.Bowed, error) { return entries[i], nil })
+}
+
+// search returns the best results among 'n' entries with respect to the
+// options given. The distance between the query and entry i is given by
+// 'dist', and entry i itself is given by 'entry'. (The latter is only called
+// for entries that may be in the results, which are then filtered.)
+//
+// The context is checked and progress is reported every progressInterval
+// entries.
+func search(
+	ctx context.Context,
+	progress bow.Progress,
+	opts SearchOptions,
+	query bow.Bowed,
+	n int,
+	dist func(i int) float64,
+	entry func(i int) (bow.Bowed, error),
+) ([]SearchResult, error) {
+	tree := new(bst)
+	for i := 0; i < n; i++ {
+		if i%progressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			progress.Report(i, n)
+		}
+
+		// Compute the distance between the query and the target.
+		dist := dist(i)
 
 		// If the distance isn't in the min/max thresholds specified, skip it.
 		if dist > opts.Max || dist < opts.Min {
@@ -119,8 +194,16 @@ func (db *DB) Search(opts SearchOptions, query bow.Bowed) []SearchResult {
 			}
 		}
 
-		// This target is good enough, add it to our results.
-		tree.insert(entry, dist)
+		// This target is good enough, add it to our results unless it's
+		// filtered out.
+		e, err := entry(i)
+		if err != nil {
+			return nil, err
+		}
+		if filtered(opts.Filters, query, e) {
+			continue
+		}
+		tree.insert(e, dist)
 
 		// This element is good enough, so lets throw away the worst
 		// result we have.
@@ -152,5 +235,181 @@ func (db *DB) Search(opts SearchOptions, query bow.Bowed) []SearchResult {
 			i += 1
 		})
 	}
-	return results
+	progress.Report(n, n)
+	return results, nil
+}
+
+// Lookup returns the entry in the database with the id given. If there is
+// more than one such entry, the first one is returned. An error is returned
+// if there is no such entry.
+//
+// Databases opened with OpenMapped are searched in place. Otherwise, all
+// entries are read if they haven't been already.
+func (db *DB) Lookup(id string) (bow.Bowed, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := db.readable(); err != nil {
+		return bow.Bowed{}, err
+	}
+	if db.fixed != nil {
+		for i := 0; i < db.fixed.len(); i++ {
+			if string(db.fixed.id(i)) == id {
+				return db.fixed.bowed(i)
+			}
+		}
+	} else {
+		entries, err := db.load(context.Background(), nil)
+		if err != nil {
+			return bow.Bowed{}, err
+		}
+		for _, entry := range entries {
+			if entry.Id == id {
+				return entry, nil
+			}
+		}
+	}
+	return bow.Bowed{}, fmt.Errorf("Could not find entry '%s' in BOW "+
+		"database '%s'.", id, db)
+}
+
+// SearchStructure computes a BOW for the query with this database's fragment
+// library and performs a search with it. An error is returned if the
+// database's fragment library is not a structure library.
+//
+// Paired libraries are both structure and sequence libraries, so databases
+// created with a paired library can be searched with either SearchStructure
+// or SearchSequence.
+func (db *DB) SearchStructure(
+	opts SearchOptions,
+	query bow.StructureBower,
+) ([]SearchResult, error) {
+	if !fragbag.IsStructure(db.Lib) {
+		return nil, fmt.Errorf("Cannot search database '%s' with a structure "+
+			"query since its fragment library (%s) is not a structure library.",
+			db, db.Lib.Name())
+	}
+	lib := db.Lib.(fragbag.StructureLibrary)
+	return db.Search(opts, query.StructureBow(lib))
+}
+
+// SearchSequence computes a BOW for the query with this database's fragment
+// library and performs a search with it. An error is returned if the
+// database's fragment library is not a sequence library.
+func (db *DB) SearchSequence(
+	opts SearchOptions,
+	query bow.SequenceBower,
+) ([]SearchResult, error) {
+	if !fragbag.IsSequence(db.Lib) {
+		return nil, fmt.Errorf("Cannot search database '%s' with a sequence "+
+			"query since its fragment library (%s) is not a sequence library.",
+			db, db.Lib.Name())
+	}
+	lib := db.Lib.(fragbag.SequenceLibrary)
+	return db.Search(opts, query.SequenceBow(lib))
+}
+
+// SearchCrossModal computes a BOW for the sequence query with the sequence
+// library given and searches this database with it. This makes it possible
+// to search a database of structures with a sequence.
+//
+// The database's fragment library must be a paired library (possibly
+// wrapped by a weighted library), and the sequence library given must be the
+// sequence half of it (possibly weighted with the same weights). Libraries
+// are compared by fingerprint, since that is the only way to be sure that
+// the fragments of both libraries are numbered the same way.
+//
+// If the database's library is weighted and the sequence library is not, then
+// the weights of the database's library are applied to the query BOW.
+//
+// An error is returned if the libraries are incompatible.
+func (db *DB) SearchCrossModal(
+	opts SearchOptions,
+	seqLib fragbag.SequenceLibrary,
+	query bow.SequenceBower,
+) ([]SearchResult, error) {
+	if err := db.compatibleSequenceLib(seqLib); err != nil {
+		return nil, err
+	}
+
+	bowed := query.SequenceBow(seqLib)
+	_, seqWeighted := seqLib.(fragbag.WeightedLibrary)
+	if dbw, ok := db.Lib.(fragbag.WeightedLibrary); ok && !seqWeighted {
+		bowed.Bow = bowed.Bow.Weighted(dbw)
+	}
+
+	// The BOW is in the same vector space as the BOWs in this database,
+	// even though it was computed with a different library.
+	bowed.Fingerprint = db.fingerprint
+	return db.Search(opts, bowed)
+}
+
+// compatibleSequenceLib returns an error if BOWs computed with the sequence
+// library given cannot be compared with BOWs in this database. Namely, the
+// sequence library must be the sequence half of the database's paired
+// library.
+func (db *DB) compatibleSequenceLib(seqLib fragbag.SequenceLibrary) error {
+	if !fragbag.IsSequence(seqLib) {
+		return fmt.Errorf("The fragment library (%s) is not a sequence "+
+			"library.", seqLib.Name())
+	}
+	paired := pairedLibrary(db.Lib)
+	if paired == nil {
+		return fmt.Errorf("The fragment library (%s) of database '%s' is not "+
+			"a paired library, so it has no sequence library to search it "+
+			"with.", db.Lib.Name(), db)
+	}
+	half := fragbag.Fingerprint(paired.SequenceLibrary())
+	if fp := fragbag.Fingerprint(unweighted(seqLib)); fp != half {
+		return fmt.Errorf("The sequence library (%s, fingerprint %s) is not "+
+			"the sequence library (%s, fingerprint %s) of the paired "+
+			"fragment library (%s) of database '%s'.",
+			seqLib.Name(), fp, paired.SequenceLibrary().Name(), half,
+			db.Lib.Name(), db)
+	}
+
+	// If both libraries are weighted, then their weights must be the same.
+	// Otherwise, if only the sequence library is weighted, the query BOW
+	// cannot be compared with unweighted BOWs in the database.
+	dbw, dbWeighted := db.Lib.(fragbag.WeightedLibrary)
+	seqw, seqWeighted := seqLib.(fragbag.WeightedLibrary)
+	switch {
+	case seqWeighted && !dbWeighted:
+		return fmt.Errorf("The sequence library (%s) is weighted, but the "+
+			"fragment library (%s) of database '%s' is not.",
+			seqLib.Name(), db.Lib.Name(), db)
+	case seqWeighted && dbWeighted:
+		for i := 0; i < db.Lib.Size(); i++ {
+			if dbw.AddWeights(i, 1) != seqw.AddWeights(i, 1) {
+				return fmt.Errorf("The weights of the sequence library (%s) "+
+					"differ from the weights of the fragment library (%s) "+
+					"of database '%s' at fragment %d.",
+					seqLib.Name(), db.Lib.Name(), db, i)
+			}
+		}
+	}
+	return nil
+}
+
+// pairedLibrary returns the paired library that the library given is or
+// wraps. It returns nil if there is none.
+func pairedLibrary(lib fragbag.Library) fragbag.PairedLibrary {
+	for lib != nil {
+		if paired, ok := lib.(fragbag.PairedLibrary); ok {
+			return paired
+		}
+		lib = lib.SubLibrary()
+	}
+	return nil
+}
+
+// unweighted returns the library wrapped by every weighted library around
+// the library given.
+func unweighted(lib fragbag.Library) fragbag.Library {
+	for {
+		if _, ok := lib.(fragbag.WeightedLibrary); !ok {
+			return lib
+		}
+		lib = lib.SubLibrary()
+	}
 }
@@ -0,0 +1,80 @@
+package bowdb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/seq"
+)
+
+// testSequenceLibrary returns a library of profiles over the alphabet "AC"
+// with the same size as the library returned by testLibrary. Every column of
+// fragment i emits 'A' with probability probA[i].
+func testSequenceLibrary(
+	t *testing.T,
+	probA ...float64,
+) fragbag.SequenceLibrary {
+	alpha := seq.Alphabet("AC")
+	frags := make([]*seq.Profile, len(probA))
+	for i, pa := range probA {
+		frags[i] = seq.NewProfileAlphabet(3, alpha)
+		for c := range frags[i].Emissions {
+			frags[i].Emissions[c].Set('A', seq.Prob(-math.Log(pa)))
+			frags[i].Emissions[c].Set('C', seq.Prob(-math.Log(1-pa)))
+		}
+	}
+	lib, err := fragbag.NewSequenceProfile("test-seq", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func TestSearchCrossModal(t *testing.T) {
+	structLib := testLibrary(t)
+	seqLib := testSequenceLibrary(t, 0.9, 0.5, 0.1)
+	paired, err := fragbag.NewPaired("test-paired", structLib, seqLib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weighted, err := fragbag.NewWeightedTfIdf(paired, []float32{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := bow.BowerFromSequence(seq.NewSequenceString("q", "AAAA"))
+
+	tests := []struct {
+		name   string
+		dbLib  fragbag.Library
+		seqLib fragbag.SequenceLibrary
+		ok     bool
+	}{
+		{"paired", paired, seqLib, true},
+		{"weighted paired", weighted, seqLib, true},
+		{"other sequence library", paired,
+			testSequenceLibrary(t, 0.9, 0.5, 0.2), false},
+		{"structure library", structLib, seqLib, false},
+	}
+	for _, test := range tests {
+		fpath := createDB(t, test.dbLib, testEntries(test.dbLib), nil, false)
+		db, err := Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := db.SearchCrossModal(SearchDefault, test.seqLib, query)
+		db.Close()
+		switch {
+		case !test.ok && err == nil:
+			t.Fatalf("%s: Expected an error.", test.name)
+		case test.ok && err != nil:
+			t.Fatalf("%s: %s", test.name, err)
+		case test.ok && results[0].Id != "a":
+			// "AAAA" has two windows that best match fragment 0, which
+			// entry 'a' has the most of.
+			t.Fatalf("%s: Expected 'a' to be the closest entry, but got "+
+				"'%s'.", test.name, results[0].Id)
+		}
+	}
+}
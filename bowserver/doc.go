@@ -0,0 +1,71 @@
+/*
+Package bowserver provides an HTTP service that searches BOW databases and
+responds with JSON.
+
+A Server serves one or more BOW databases, each named by the base name of its
+file path. The following endpoints are available:
+
+	GET  /databases      Lists every database served along with its
+	                     fragment library and number of entries.
+	POST /search/{name}  Searches the database named with the queries in
+	                     the request body.
+	GET  /metrics        Reports counts of requests, searches, errors and
+	                     reloads, along with time spent searching. Times
+	                     are given in nanoseconds.
+
+Parameters are always given in the query string of the URL, since the body
+of a search request holds its queries. The 'type' parameter of a search
+request determines how its body is read:
+
+	pdb    A PDB file. A query is made for every protein chain.
+	cif    An mmCIF file. A query is made for every chain.
+	fasta  A FASTA file. A query is made for every sequence.
+	msa    A multiple sequence alignment in aligned FASTA or A3M format.
+	       A single query is made for the first sequence of the alignment
+	       from the profile of the alignment.
+	bow    A JSON encoded bow.Bowed value, or just a bow.Bow value.
+	id     The id of an entry in the database, which is used as the query.
+	       The id may also be given with the 'id' parameter.
+
+For structure queries, a query is made for every model of every chain
+instead if the 'models' parameter is 'true'. If the 'ensemble' parameter is
+'true', the query for every chain is the average of the BOWs of all of its
+models.
+
+Search options are given with the 'limit', 'min', 'max', 'sort' ('cosine' or
+'euclid') and 'order' ('asc' or 'desc') parameters. Options that are not
+given default to the values in bowdb.SearchDefault.
+
+Results are filtered with the following parameters:
+
+	id-prefix           Only include entries with ids starting with this
+	                    prefix. May be given more than once, in which case
+	                    entries starting with any of the prefixes are
+	                    included.
+	exclude-id-prefix   Exclude entries with ids starting with this prefix.
+	                    May be given more than once.
+	id-regexp           Only include entries with ids matching this regular
+	                    expression.
+	exclude-id-regexp   Exclude entries with ids matching this regular
+	                    expression.
+	exclude-query       When 'true', exclude entries with the same id as
+	                    the query.
+	exclude-same-entry  When 'true', exclude entries from the same PDB entry
+	                    as the query.
+	meta                A 'key=value' pair. Only include entries whose meta
+	                    data has the value for the key (see
+	                    bow.Metadata.Get). May be given more than once.
+
+The response of a search is a JSON list with an object for every query. Each
+object has a "Query" key with the id of the query and a "Results" key with a
+list of bowdb.SearchResult values.
+
+Errors are reported with an appropriate HTTP status code and a JSON object
+with an "Error" key.
+
+Databases are reloaded when their files change (see Server.Watch). Searches
+in progress while a database is reloaded finish with the old database. A
+database file must be replaced by renaming a new file over it, and never
+modified in place (see Server.Reload).
+*/
+package bowserver
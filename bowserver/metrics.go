@@ -0,0 +1,98 @@
+package bowserver
+
+import (
+	"sync"
+	"time"
+)
+
+// metrics counts requests, searches, errors and reloads of a server.
+type metrics struct {
+	sync.Mutex
+	started      time.Time
+	requests     int64
+	errors       int64
+	reloads      int64
+	reloadErrors int64
+	databases    map[string]*dbMetrics
+}
+
+// dbMetrics counts searches of a single database.
+type dbMetrics struct {
+	Searches int64
+	Queries  int64
+	Time     time.Duration
+}
+
+// jsonMetrics is the JSON representation of a server's metrics.
+type jsonMetrics struct {
+	Uptime       time.Duration
+	Requests     int64
+	Errors       int64
+	Reloads      int64
+	ReloadErrors int64
+	Databases    map[string]dbMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		started:   time.Now(),
+		databases: make(map[string]*dbMetrics),
+	}
+}
+
+func (m *metrics) request() {
+	m.Lock()
+	m.requests++
+	m.Unlock()
+}
+
+func (m *metrics) error() {
+	m.Lock()
+	m.errors++
+	m.Unlock()
+}
+
+func (m *metrics) reload(err error) {
+	m.Lock()
+	if err != nil {
+		m.reloadErrors++
+	} else {
+		m.reloads++
+	}
+	m.Unlock()
+}
+
+// search records a search of the database named with the number of queries
+// in the search and the time it took.
+func (m *metrics) search(name string, took time.Duration, queries int) {
+	m.Lock()
+	defer m.Unlock()
+
+	dbm, ok := m.databases[name]
+	if !ok {
+		dbm = new(dbMetrics)
+		m.databases[name] = dbm
+	}
+	dbm.Searches++
+	dbm.Queries += int64(queries)
+	dbm.Time += took
+}
+
+// snapshot returns a copy of the metrics suitable for JSON encoding.
+func (m *metrics) snapshot() jsonMetrics {
+	m.Lock()
+	defer m.Unlock()
+
+	s := jsonMetrics{
+		Uptime:       time.Since(m.started),
+		Requests:     m.requests,
+		Errors:       m.errors,
+		Reloads:      m.reloads,
+		ReloadErrors: m.reloadErrors,
+		Databases:    make(map[string]dbMetrics, len(m.databases)),
+	}
+	for name, dbm := range m.databases {
+		s.Databases[name] = *dbm
+	}
+	return s
+}
@@ -0,0 +1,329 @@
+package bowserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/fragbag/bowdb"
+	"github.com/TuftsBCB/io/fasta"
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+)
+
+// jsonQuery is the JSON representation of all results for a single query.
+type jsonQuery struct {
+	Query   string
+	Results []bowdb.SearchResult
+}
+
+// searchOptions reads search options from the parameters of a request.
+// Options that are not given default to bowdb.SearchDefault.
+func searchOptions(params url.Values) (bowdb.SearchOptions, error) {
+	opts := bowdb.SearchDefault
+	var err error
+
+	if v := params.Get("limit"); len(v) > 0 {
+		if opts.Limit, err = strconv.Atoi(v); err != nil {
+			return opts, badRequest("Invalid limit '%s'.", v)
+		}
+	}
+	if v := params.Get("min"); len(v) > 0 {
+		if opts.Min, err = strconv.ParseFloat(v, 64); err != nil {
+			return opts, badRequest("Invalid minimum '%s'.", v)
+		}
+	}
+	if v := params.Get("max"); len(v) > 0 {
+		if opts.Max, err = strconv.ParseFloat(v, 64); err != nil {
+			return opts, badRequest("Invalid maximum '%s'.", v)
+		}
+	}
+	switch v := params.Get("sort"); v {
+	case "":
+	case "cosine":
+		opts.SortBy = bowdb.SortByCosine
+	case "euclid":
+		opts.SortBy = bowdb.SortByEuclid
+	default:
+		return opts, badRequest("Unrecognized sort metric '%s'.", v)
+	}
+	switch v := params.Get("order"); v {
+	case "":
+	case "asc":
+		opts.Order = bowdb.OrderAsc
+	case "desc":
+		opts.Order = bowdb.OrderDesc
+	default:
+		return opts, badRequest("Unrecognized order '%s'.", v)
+	}
+	if prefixes := params["id-prefix"]; len(prefixes) > 0 {
+		opts.Filters = append(opts.Filters, bowdb.IdPrefix(prefixes...))
+	}
+	if prefixes := params["exclude-id-prefix"]; len(prefixes) > 0 {
+		opts.Filters = append(opts.Filters,
+			bowdb.Not(bowdb.IdPrefix(prefixes...)))
+	}
+	for _, name := range []string{"id-regexp", "exclude-id-regexp"} {
+		v := params.Get(name)
+		if len(v) == 0 {
+			continue
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return opts, badRequest("Invalid regular expression '%s': %s",
+				v, err)
+		}
+		f := bowdb.IdRegexp(re)
+		if name == "exclude-id-regexp" {
+			f = bowdb.Not(f)
+		}
+		opts.Filters = append(opts.Filters, f)
+	}
+	if params.Get("exclude-query") == "true" {
+		opts.Filters = append(opts.Filters, bowdb.ExcludeQuery)
+	}
+	if params.Get("exclude-same-entry") == "true" {
+		opts.Filters = append(opts.Filters, bowdb.ExcludeSameEntry)
+	}
+	for _, pair := range params["meta"] {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return opts, badRequest("Invalid meta data value '%s'.", pair)
+		}
+		opts.Filters = append(opts.Filters, bowdb.MetaEquals(kv[0], kv[1]))
+	}
+	return opts, nil
+}
+
+// readBody reads the entire body of the request, up to maxBodySize bytes.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return nil, badRequest("Could not read request: %s", err)
+	}
+	return body, nil
+}
+
+// search computes a query for every structure, sequence or BOW in the body
+// of a request and searches the database with each of them.
+func search(
+	ctx context.Context,
+	db *bowdb.DB,
+	opts bowdb.SearchOptions,
+	params url.Values,
+	body []byte,
+) ([]jsonQuery, error) {
+	queries, err := queries(db, params, body)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]jsonQuery, len(queries))
+	for i, query := range queries {
+		rs, err := db.SearchContext(ctx, opts, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = jsonQuery{query.Id, rs}
+	}
+	return results, nil
+}
+
+// queries computes a bowed value with the database's fragment library for
+// every structure, sequence, alignment or BOW in the body of a request, as
+// determined by the 'type' parameter.
+func queries(
+	db *bowdb.DB,
+	params url.Values,
+	body []byte,
+) ([]bow.Bowed, error) {
+	switch t := params.Get("type"); t {
+	case "pdb", "cif":
+		if !fragbag.IsStructure(db.Lib) {
+			return nil, badRequest("Structure queries require a database "+
+				"with a structure fragment library, but '%s' has a sequence "+
+				"fragment library.", db)
+		}
+		lib := db.Lib.(fragbag.StructureLibrary)
+
+		models := params.Get("models") == "true"
+		ensemble := params.Get("ensemble") == "true"
+		mode := bow.FirstModel
+		switch {
+		case models && ensemble:
+			return nil, badRequest("At most one of 'models' and " +
+				"'ensemble' may be 'true'.")
+		case models:
+			mode = bow.EveryModel
+		case ensemble:
+			mode = bow.EnsembleModels
+		}
+
+		var bowers []bow.StructureBower
+		var err error
+		if t == "cif" {
+			bowers, err = cifBowers(body, mode)
+		} else {
+			bowers, err = pdbBowers(body, mode)
+		}
+		if err != nil {
+			return nil, badRequest("Could not read %s file: %s", t, err)
+		}
+		queries := make([]bow.Bowed, len(bowers))
+		for i, bower := range bowers {
+			queries[i] = bower.StructureBow(lib)
+		}
+		return queries, nil
+	case "fasta":
+		if !fragbag.IsSequence(db.Lib) {
+			return nil, badRequest("Sequence queries require a database "+
+				"with a sequence fragment library, but '%s' has a structure "+
+				"fragment library.", db)
+		}
+		lib := db.Lib.(fragbag.SequenceLibrary)
+
+		seqs, err := fasta.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			return nil, badRequest("Could not read FASTA file: %s", err)
+		}
+		queries := make([]bow.Bowed, len(seqs))
+		for i, s := range seqs {
+			queries[i] = bow.BowerFromSequence(s).SequenceBow(lib)
+		}
+		return queries, nil
+	case "msa":
+		if !fragbag.IsSequence(db.Lib) {
+			return nil, badRequest("Alignment queries require a database "+
+				"with a sequence fragment library, but '%s' has a structure "+
+				"fragment library.", db)
+		}
+		lib := db.Lib.(fragbag.SequenceLibrary)
+
+		msa, err := bow.ReadAlignment(bytes.NewReader(body))
+		if err != nil {
+			return nil, badRequest("Could not read alignment: %s", err)
+		}
+		bower, err := bow.BowerFromAlignment(msa)
+		if err != nil {
+			return nil, badRequest("Could not read alignment: %s", err)
+		}
+		return []bow.Bowed{bower.SequenceBow(lib)}, nil
+	case "bow":
+		var query bow.Bowed
+		if err := json.Unmarshal(body, &query); err != nil {
+			return nil, badRequest("Could not read BOW: %s", err)
+		}
+		if query.Bow.Len() == 0 {
+			// Accept a bare bow.Bow too.
+			if err := json.Unmarshal(body, &query.Bow); err != nil {
+				return nil, badRequest("Could not read BOW: %s", err)
+			}
+		}
+		if err := compatible(db, query); err != nil {
+			return nil, err
+		}
+		return []bow.Bowed{query}, nil
+	case "id":
+		id := params.Get("id")
+		if len(id) == 0 {
+			id = strings.TrimSpace(string(body))
+		}
+		query, err := db.Lookup(id)
+		if err == bowdb.ErrClosed {
+			return nil, err
+		} else if err != nil {
+			return nil, httpError{http.StatusNotFound, err}
+		}
+		return []bow.Bowed{query}, nil
+	case "":
+		return nil, badRequest("No query type given.")
+	default:
+		return nil, badRequest("Unrecognized query type '%s'.", t)
+	}
+}
+
+// compatible returns a "bad request" error if the BOW given by a client
+// cannot be used to search the database given.
+func compatible(db *bowdb.DB, query bow.Bowed) error {
+	if query.Bow.Len() != db.Lib.Size() {
+		return badRequest("The BOW has size %d, but the fragment library "+
+			"of '%s' has size %d.", query.Bow.Len(), db, db.Lib.Size())
+	}
+	fp := db.Fingerprint()
+	if len(query.Fingerprint) > 0 && len(fp) > 0 && query.Fingerprint != fp {
+		return badRequest("The BOW was computed with a fragment library "+
+			"that differs from the fragment library of '%s'.", db)
+	}
+	return nil
+}
+
+// pdbBowers returns a structure bower for every protein chain in the PDB
+// file given, with the models of each chain chosen by the mode given.
+func pdbBowers(body []byte, mode bow.ModelMode) ([]bow.StructureBower, error) {
+	// PDB files can only be read from disk.
+	f, err := ioutil.TempFile("", "fragbag-query-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	entry, err := pdb.ReadPDB(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	bowers := bow.PdbBowers(entry, mode)
+	if len(bowers) == 0 {
+		return nil, fmt.Errorf("No protein chains found.")
+	}
+	return withResolution(body, bowers)
+}
+
+// cifBowers returns a structure bower for every chain in the mmCIF file
+// given, with the models of each chain chosen by the mode given.
+func cifBowers(body []byte, mode bow.ModelMode) ([]bow.StructureBower, error) {
+	entries, err := pdbx.Read(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var bowers []bow.StructureBower
+	for _, entry := range entries {
+		bowers = append(bowers, bow.CifBowers(entry, "", mode)...)
+	}
+	if len(bowers) == 0 {
+		return nil, fmt.Errorf("No chains found.")
+	}
+	return withResolution(body, bowers)
+}
+
+// withResolution records the resolution of the PDB or mmCIF file given in
+// the meta data of the BOWs of every bower given. (See bow.ReadResolution.)
+func withResolution(
+	body []byte,
+	bowers []bow.StructureBower,
+) ([]bow.StructureBower, error) {
+	res, err := bow.ReadResolution(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range bowers {
+		bowers[i] = bow.BowerWithResolution(b, res)
+	}
+	return bowers, nil
+}
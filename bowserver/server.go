@@ -0,0 +1,364 @@
+package bowserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	path "path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TuftsBCB/fragbag/bowdb"
+)
+
+// maxBodySize is the maximum size in bytes of the body of a request.
+const maxBodySize = 64 << 20
+
+// Server is an http.Handler that searches BOW databases. It is safe to use
+// from multiple goroutines.
+type Server struct {
+	// When true, databases are opened with bowdb.OpenMapped.
+	mapped bool
+
+	mu       sync.RWMutex // Protects dbs.
+	dbs      map[string]*database
+	reloadMu sync.Mutex // Serializes calls to Reload.
+
+	metrics *metrics
+	mux     *http.ServeMux
+}
+
+// database is a BOW database served along with the state of its file when
+// it was opened.
+type database struct {
+	fpath string
+	db    *bowdb.DB
+	info  os.FileInfo
+}
+
+// NewServer opens every BOW database given and returns a server for them.
+// If mapped is true, databases are opened with bowdb.OpenMapped. Otherwise,
+// they are opened with bowdb.Open and all of their entries are read into
+// memory.
+//
+// An error is returned if any database cannot be opened or if two databases
+// have the same name.
+func NewServer(fpaths []string, mapped bool) (*Server, error) {
+	s := &Server{
+		mapped:  mapped,
+		dbs:     make(map[string]*database, len(fpaths)),
+		metrics: newMetrics(),
+		mux:     http.NewServeMux(),
+	}
+	for _, fpath := range fpaths {
+		d, err := s.open(fpath)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		name := d.db.Name
+		if _, ok := s.dbs[name]; ok {
+			d.db.Close()
+			s.Close()
+			return nil, fmt.Errorf("More than one BOW database is named "+
+				"'%s'.", name)
+		}
+		s.dbs[name] = d
+	}
+
+	s.mux.HandleFunc("/databases", s.handleDatabases)
+	s.mux.HandleFunc("/search/", s.handleSearch)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	return s, nil
+}
+
+// open opens the BOW database at the path given. Unless it is memory mapped,
+// all of its entries are read, so that the first search is not slow.
+func (s *Server) open(fpath string) (*database, error) {
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	var db *bowdb.DB
+	if s.mapped {
+		db, err = bowdb.OpenMapped(fpath)
+	} else {
+		db, err = bowdb.Open(fpath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not open BOW database '%s': %s",
+			fpath, err)
+	}
+	if !s.mapped {
+		if _, err := db.ReadAll(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Could not read BOW database '%s': %s",
+				fpath, err)
+		}
+	}
+	return &database{fpath, db, info}, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.metrics.request()
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close closes every database served.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for name, d := range s.dbs {
+		if cerr := d.db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.dbs, name)
+	}
+	return err
+}
+
+// Reload reopens every database whose file has changed since it was opened,
+// which is detected with its modification time and size, or by its file
+// having been replaced with another file. Searches in progress finish with
+// the old database, which is then closed.
+//
+// If a database cannot be reopened, the old database continues to be
+// served and reopening it is tried again on the next call. The first error
+// encountered is returned.
+//
+// A database file must never be modified in place while it is served.
+// Instead, the new database must be written to another path on the same
+// file system and renamed over the old one, so that the old file is left
+// intact until it is closed. This is required when databases are memory
+// mapped, since a mapped file that is truncated or rewritten in place makes
+// searches of the old database crash the process with SIGBUS. Otherwise, a
+// database that is modified in place may also be reopened before it is
+// completely written.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.mu.RLock()
+	var changed []*database
+	for _, d := range s.dbs {
+		info, err := os.Stat(d.fpath)
+		if err != nil {
+			continue
+		}
+		if !os.SameFile(info, d.info) ||
+			!info.ModTime().Equal(d.info.ModTime()) ||
+			info.Size() != d.info.Size() {
+			changed = append(changed, d)
+		}
+	}
+	s.mu.RUnlock()
+
+	var err error
+	for _, old := range changed {
+		d, oerr := s.open(old.fpath)
+		if oerr == nil && d.db.Name != old.db.Name {
+			d.db.Close()
+			oerr = fmt.Errorf("BOW database '%s' was reopened with the "+
+				"name '%s'.", old.db.Name, d.db.Name)
+		}
+		if oerr != nil {
+			s.metrics.reload(oerr)
+			if err == nil {
+				err = oerr
+			}
+			continue
+		}
+		s.metrics.reload(nil)
+
+		s.mu.Lock()
+		if s.dbs[old.db.Name] != old { // the server was closed
+			s.mu.Unlock()
+			d.db.Close()
+			continue
+		}
+		s.dbs[old.db.Name] = d
+		s.mu.Unlock()
+
+		// Close waits for searches in progress to finish.
+		go old.db.Close()
+	}
+	return err
+}
+
+// Watch calls Reload at the interval given until the context is cancelled.
+// Errors are logged. Database files must be replaced by renaming new files
+// over them (see Reload).
+func (s *Server) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				log.Printf("Could not reload: %s", err)
+			}
+		}
+	}
+}
+
+// db returns the database with the name given.
+func (s *Server) db(name string) (*bowdb.DB, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.dbs[name]
+	if !ok {
+		return nil, httpError{http.StatusNotFound,
+			fmt.Errorf("Unknown BOW database '%s'.", name)}
+	}
+	return d.db, nil
+}
+
+// jsonDatabase is the JSON representation of a database served.
+type jsonDatabase struct {
+	Name, Library, Fingerprint string
+	Entries                    int
+	Created                    time.Time
+}
+
+func (s *Server) handleDatabases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.error(w, httpError{http.StatusMethodNotAllowed,
+			fmt.Errorf("Method %s is not allowed.", r.Method)})
+		return
+	}
+
+	s.mu.RLock()
+	dbs := make([]jsonDatabase, 0, len(s.dbs))
+	for name, d := range s.dbs {
+		m := d.db.Manifest()
+		dbs = append(dbs, jsonDatabase{
+			Name:        name,
+			Library:     d.db.Lib.Name(),
+			Fingerprint: d.db.Fingerprint(),
+			Entries:     m.Entries,
+			Created:     m.Created,
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(byName(dbs))
+	s.respond(w, dbs)
+}
+
+type byName []jsonDatabase
+
+func (dbs byName) Len() int           { return len(dbs) }
+func (dbs byName) Less(i, j int) bool { return dbs[i].Name < dbs[j].Name }
+func (dbs byName) Swap(i, j int)      { dbs[i], dbs[j] = dbs[j], dbs[i] }
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.error(w, httpError{http.StatusMethodNotAllowed,
+			fmt.Errorf("Method %s is not allowed.", r.Method)})
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/search/")
+	if len(name) == 0 || path.Base(name) != name {
+		s.error(w, httpError{http.StatusNotFound,
+			fmt.Errorf("No BOW database given.")})
+		return
+	}
+
+	params := r.URL.Query()
+	opts, err := searchOptions(params)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	// If the database is reloaded after it's retrieved, it is closed once
+	// the search is done with it. So if it's already closed, just try again
+	// with the new database.
+	var results []jsonQuery
+	for tries := 0; tries < 2; tries++ {
+		var db *bowdb.DB
+		if db, err = s.db(name); err != nil {
+			break
+		}
+
+		start := time.Now()
+		results, err = search(r.Context(), db, opts, params, body)
+		if err == nil {
+			s.metrics.search(name, time.Since(start), len(results))
+		}
+		if err != bowdb.ErrClosed {
+			break
+		}
+	}
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	s.respond(w, results)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.error(w, httpError{http.StatusMethodNotAllowed,
+			fmt.Errorf("Method %s is not allowed.", r.Method)})
+		return
+	}
+	s.respond(w, s.metrics.snapshot())
+}
+
+// httpError is an error with an HTTP status code.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (err httpError) Error() string {
+	return err.err.Error()
+}
+
+// badRequest returns an error with a "bad request" status code.
+func badRequest(format string, v ...interface{}) error {
+	return httpError{http.StatusBadRequest, fmt.Errorf(format, v...)}
+}
+
+// error responds with the error given. Errors without a status code are
+// reported as internal server errors, unless they are caused by the request
+// being cancelled.
+func (s *Server) error(w http.ResponseWriter, err error) {
+	s.metrics.error()
+
+	status := http.StatusInternalServerError
+	if herr, ok := err.(httpError); ok {
+		status = herr.status
+	} else if err == context.Canceled || err == context.DeadlineExceeded {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct{ Error string }{err.Error()})
+}
+
+// respond responds with the JSON encoding of the value given.
+func (s *Server) respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Could not write response: %s", err)
+	}
+}
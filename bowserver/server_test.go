@@ -0,0 +1,189 @@
+package bowserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	path "path/filepath"
+	"testing"
+
+	"github.com/TuftsBCB/fragbag/bow"
+)
+
+// testBowed returns an entry with the id and frequencies given.
+func testBowed(id string, freqs ...float32) bow.Bowed {
+	b := bow.NewBow(len(freqs))
+	copy(b.Freqs, freqs)
+	return bow.Bowed{Id: id, Bow: b}
+}
+
+// copyTestData copies a database in the testdata directory to the path
+// given. The database 'test.bowdb' has entries 'a', 'b' and 'c', and the
+// database 'reloaded.bowdb' has entries 'a' and 'd'. Both are mappable.
+func copyTestData(t *testing.T, name, fpath string) {
+	bs, err := ioutil.ReadFile(path.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fpath, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testServer returns a server for a copy of the database 'test.bowdb',
+// along with the path of the copy.
+func testServer(t *testing.T, mapped bool) (*Server, string) {
+	fpath := path.Join(t.TempDir(), "test.bowdb")
+	copyTestData(t, "test.bowdb", fpath)
+
+	s, err := NewServer([]string{fpath}, mapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fpath
+}
+
+// post sends a POST request with the body given to the server and decodes
+// the response into 'v'. The status code of the response is returned.
+func post(t *testing.T, s *Server, url, body string, v interface{}) int {
+	r := httptest.NewRequest("POST", url, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("Could not decode response to %s: %s", url, err)
+	}
+	return w.Code
+}
+
+// searchIds searches the server and returns the ids of the results of each
+// query. The test fails if the search does not succeed.
+func searchIds(t *testing.T, s *Server, url, body string) [][]string {
+	var queries []jsonQuery
+	if code := post(t, s, url, body, &queries); code != http.StatusOK {
+		t.Fatalf("Expected status %d for %s but got %d.",
+			http.StatusOK, url, code)
+	}
+	ids := make([][]string, len(queries))
+	for i, q := range queries {
+		for _, r := range q.Results {
+			ids[i] = append(ids[i], r.Id)
+		}
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	for _, mapped := range []bool{false, true} {
+		s, _ := testServer(t, mapped)
+		defer s.Close()
+
+		query, err := json.Marshal(testBowed("q", 0, 3, 0.1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			url, body string
+			expected  []string
+		}{
+			{"/search/test.bowdb?type=bow", string(query),
+				[]string{"b", "c", "a"}},
+			{"/search/test.bowdb?type=bow&limit=1", `{"Freqs":[1,0,0.5]}`,
+				[]string{"a"}},
+			{"/search/test.bowdb?type=id&id=c&exclude-query=true", "",
+				[]string{"a", "b"}},
+			{"/search/test.bowdb?type=id", "a\n",
+				[]string{"a", "c", "b"}},
+			{"/search/test.bowdb?type=id&id=a&id-prefix=b&id-prefix=c", "",
+				[]string{"c", "b"}},
+			{"/search/test.bowdb?type=id&id=a&exclude-id-prefix=c", "",
+				[]string{"a", "b"}},
+		}
+		for _, test := range tests {
+			ids := searchIds(t, s, test.url, test.body)
+			if len(ids) != 1 || !equalIds(ids[0], test.expected) {
+				t.Fatalf("Expected results %v for %s (mapped: %v), but "+
+					"got %v.", test.expected, test.url, mapped, ids)
+			}
+		}
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	s, _ := testServer(t, false)
+	defer s.Close()
+
+	tests := []struct {
+		url, body string
+		status    int
+	}{
+		{"/search/test.bowdb", "", http.StatusBadRequest},
+		{"/search/test.bowdb?type=xyz", "", http.StatusBadRequest},
+		{"/search/test.bowdb?type=bow", "{", http.StatusBadRequest},
+		{"/search/test.bowdb?type=bow", `{"Freqs":[1]}`,
+			http.StatusBadRequest},
+		{"/search/test.bowdb?type=id&sort=x", "a", http.StatusBadRequest},
+		{"/search/test.bowdb?type=fasta", ">a\nAC\n", http.StatusBadRequest},
+		{"/search/test.bowdb?type=id", "zzz", http.StatusNotFound},
+		{"/search/nope.bowdb?type=id", "a", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		var resp struct{ Error string }
+		code := post(t, s, test.url, test.body, &resp)
+		if code != test.status || len(resp.Error) == 0 {
+			t.Fatalf("Expected status %d with an error for %s, but got "+
+				"status %d with error '%s'.",
+				test.status, test.url, code, resp.Error)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	for _, mapped := range []bool{false, true} {
+		s, fpath := testServer(t, mapped)
+		defer s.Close()
+
+		// Replace the database by renaming a new one over it.
+		tmp := fpath + ".new"
+		copyTestData(t, "reloaded.bowdb", tmp)
+		if err := os.Rename(tmp, fpath); err != nil {
+			t.Fatal(err)
+		}
+
+		before := searchIds(t, s, "/search/test.bowdb?type=id&id=a", "")
+		if err := s.Reload(); err != nil {
+			t.Fatalf("Could not reload (mapped: %v): %s", mapped, err)
+		}
+		after := searchIds(t, s, "/search/test.bowdb?type=id&id=a", "")
+
+		expected := []string{"a", "c", "b"}
+		if len(before) != 1 || !equalIds(before[0], expected) {
+			t.Fatalf("Expected results %v before reloading (mapped: %v), "+
+				"but got %v.", expected, mapped, before)
+		}
+		expected = []string{"a", "d"}
+		if len(after) != 1 || !equalIds(after[0], expected) {
+			t.Fatalf("Expected results %v after reloading (mapped: %v), "+
+				"but got %v.", expected, mapped, after)
+		}
+		if m := s.metrics.snapshot(); m.Reloads != 1 || m.ReloadErrors != 0 {
+			t.Fatalf("Expected 1 reload and no errors (mapped: %v), but "+
+				"got %d reloads and %d errors.",
+				mapped, m.Reloads, m.ReloadErrors)
+		}
+	}
+}
+
+func equalIds(ids1, ids2 []string) bool {
+	if len(ids1) != len(ids2) {
+		return false
+	}
+	for i := range ids1 {
+		if ids1[i] != ids2[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package fragbag
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/structure"
+)
+
+// This file provides interoperability with the fragment libraries distributed
+// with the original Fragbag program (written by Rachel Kolodny). Those
+// libraries are stored in Brookhaven (PDB) format, usually with a '.brk' file
+// extension.
+//
+// Each fragment in the file is a list of ATOM records, one for each
+// alpha-carbon in the fragment. Fragments are delimited by TER records or by
+// lines starting with a '-' character. Lines that contain exactly three
+// numbers are also accepted as alpha-carbon coordinates. All other lines are
+// ignored.
+
+// OpenBrk reads a structure fragment library in the original Fragbag format
+// and returns it with the name given. Fragments are numbered in the order in
+// which they appear in the file.
+func OpenBrk(r io.Reader, name string) (StructureLibrary, error) {
+	var frags [][]structure.Coords
+	var frag []structure.Coords
+	addFrag := func() {
+		if len(frag) > 0 {
+			frags = append(frags, frag)
+			frag = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "-"),
+			strings.HasPrefix(line, "TER"),
+			strings.HasPrefix(line, "END"):
+			addFrag()
+		case strings.HasPrefix(line, "ATOM"),
+			strings.HasPrefix(line, "HETATM"):
+			coords, err := brkAtomCoords(line)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %s", lineNum, err)
+			}
+			frag = append(frag, coords)
+		default:
+			if coords, ok := brkPlainCoords(line); ok {
+				frag = append(frag, coords)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	addFrag()
+
+	if len(frags) == 0 {
+		return nil, fmt.Errorf("No fragments found in library '%s'.", name)
+	}
+	return NewStructureAtoms(name, frags)
+}
+
+// brkAtomCoords reads the coordinates in the fixed columns of an ATOM
+// record.
+func brkAtomCoords(line string) (structure.Coords, error) {
+	if len(line) < 54 {
+		return structure.Coords{}, fmt.Errorf("ATOM record is too short to "+
+			"contain coordinates: '%s'", line)
+	}
+
+	var xyz [3]float64
+	for i := range xyz {
+		field := strings.TrimSpace(line[30+8*i : 38+8*i])
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return structure.Coords{}, fmt.Errorf("Could not parse "+
+				"coordinate '%s': %s", field, err)
+		}
+		xyz[i] = f
+	}
+	return structure.Coords{X: xyz[0], Y: xyz[1], Z: xyz[2]}, nil
+}
+
+// brkPlainCoords reads a line with exactly three whitespace separated
+// numbers. If the line is not of that form, false is returned.
+func brkPlainCoords(line string) (structure.Coords, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return structure.Coords{}, false
+	}
+
+	var xyz [3]float64
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return structure.Coords{}, false
+		}
+		xyz[i] = f
+	}
+	return structure.Coords{X: xyz[0], Y: xyz[1], Z: xyz[2]}, true
+}
+
+// SaveBrk writes the structure fragment library given in the original
+// Fragbag format. Each fragment is written as a list of alpha-carbon ATOM
+// records followed by a TER record. The library can be read again with
+// OpenBrk, although its name is not saved.
+func SaveBrk(w io.Writer, lib StructureLibrary) error {
+	buf := bufio.NewWriter(w)
+	serial := 1
+	for fragNum := 0; fragNum < lib.Size(); fragNum++ {
+		for i, atom := range lib.Atoms(fragNum) {
+			_, err := fmt.Fprintf(buf,
+				"ATOM  %5d  CA  GLY A%4d    %8.3f%8.3f%8.3f  1.00  0.00\n",
+				serial%100000, i+1, atom.X, atom.Y, atom.Z)
+			if err != nil {
+				return err
+			}
+			serial++
+		}
+		if _, err := fmt.Fprintf(buf, "TER\n"); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(buf, "END\n"); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
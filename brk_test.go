@@ -0,0 +1,73 @@
+package fragbag
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/TuftsBCB/structure"
+)
+
+func TestBrkRoundTrip(t *testing.T) {
+	frags := [][]structure.Coords{
+		{{1, 2, 3}, {4.5, -5.25, 6}, {-7.125, 8, 9.5}},
+		{{0, 0, 0}, {3.8, 0, 0}, {7.6, 1.2, -0.5}},
+	}
+	lib, err := NewStructureAtoms("test", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := SaveBrk(buf, lib); err != nil {
+		t.Fatalf("Could not write library: %s", err)
+	}
+	read, err := OpenBrk(buf, "test")
+	if err != nil {
+		t.Fatalf("Could not read library: %s", err)
+	}
+	assertSameAtoms(t, lib, read)
+}
+
+func TestBrkPlain(t *testing.T) {
+	brk := `------------------------------------
+ 1.0 2.0 3.0
+ 4.5 -5.25 6.0
+ -7.125 8.0 9.5
+------------------------------------
+ 0.0 0.0 0.0
+ 3.8 0.0 0.0
+ 7.6 1.2 -0.5
+`
+	read, err := OpenBrk(strings.NewReader(brk), "test")
+	if err != nil {
+		t.Fatalf("Could not read library: %s", err)
+	}
+	lib, err := NewStructureAtoms("test", [][]structure.Coords{
+		{{1, 2, 3}, {4.5, -5.25, 6}, {-7.125, 8, 9.5}},
+		{{0, 0, 0}, {3.8, 0, 0}, {7.6, 1.2, -0.5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameAtoms(t, lib, read)
+}
+
+func assertSameAtoms(t *testing.T, expected, got StructureLibrary) {
+	if expected.Size() != got.Size() {
+		t.Fatalf("Expected %d fragments but got %d.",
+			expected.Size(), got.Size())
+	}
+	if expected.FragmentSize() != got.FragmentSize() {
+		t.Fatalf("Expected fragment size %d but got %d.",
+			expected.FragmentSize(), got.FragmentSize())
+	}
+	for i := 0; i < expected.Size(); i++ {
+		for j, atom := range expected.Atoms(i) {
+			if atom != got.Atoms(i)[j] {
+				t.Fatalf("Atom %d of fragment %d: expected %s but got %s.",
+					j, i, atom, got.Atoms(i)[j])
+			}
+		}
+	}
+}
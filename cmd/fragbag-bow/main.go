@@ -0,0 +1,138 @@
+// fragbag-bow computes BOWs for every chain (or every model of every chain)
+// in PDB or mmCIF files with a structure fragment library. With the
+// '-ensemble' flag, the BOW of every chain is the average of the BOWs of all
+// of its models.
+//
+// Usage:
+//
+//	fragbag-bow [flags] frag-lib-file (pdb-file | cif-file | dir) ...
+//
+// Directories are searched recursively for PDB and mmCIF files. Files ending
+// in '.cif' or '.cif.gz' are read as mmCIF files and every other file is read
+// as a PDB file. Files that cannot be read are reported on stderr and
+// skipped.
+//
+// BOWs are written to stdout in one of three formats, chosen with the
+// '-format' flag:
+//
+//	tsv   The id followed by the frequency of every fragment, separated by
+//	      tabs.
+//	json  One JSON object per line with "Id" and "Freqs" keys.
+//	old   The id and the BOW in the original Fragbag string format (see
+//	      bow.Bow.StringOldStyle), separated by a tab. This format cannot
+//	      be used with weighted fragment libraries.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+var (
+	flagFormat   = "tsv"
+	flagModels   = false
+	flagEnsemble = false
+	flagCpu      = runtime.NumCPU()
+
+	// flagMode is the mode corresponding to the '-models' and '-ensemble'
+	// flags.
+	flagMode bow.ModelMode
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&flagFormat, "format", flagFormat,
+		"The output format: 'tsv', 'json' or 'old'.")
+	flag.BoolVar(&flagModels, "models", flagModels,
+		"When set, a BOW is computed for every model of every chain instead "+
+			"of only the first model of every chain.")
+	flag.BoolVar(&flagEnsemble, "ensemble", flagEnsemble,
+		"When set, the BOW of every chain is the average of the BOWs of "+
+			"all of its models, as for NMR ensembles.")
+	flag.IntVar(&flagCpu, "cpu", flagCpu,
+		"The number of files to process in parallel.")
+
+	flag.Usage = usage
+	flag.Parse()
+	flagMode = util.ModelMode(flagModels, flagEnsemble)
+
+	runtime.GOMAXPROCS(flagCpu)
+}
+
+func usage() {
+	log.Printf("Usage: %s [flags] frag-lib-file "+
+		"(pdb-file | cif-file | dir) ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	if flag.NArg() < 2 {
+		flag.Usage()
+	}
+	switch flagFormat {
+	case "tsv", "json", "old":
+	default:
+		log.Fatalf("Unrecognized output format '%s'.", flagFormat)
+	}
+
+	lib := util.StructureLibrary(flag.Arg(0))
+	files := util.Files(flag.Args()[1:]...)
+	bowed := make(chan bow.Bowed)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < flagCpu; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fpath := range files {
+				bowers, err := util.StructureBowers(fpath, flagMode)
+				if err != nil {
+					log.Printf("Could not read '%s': %s", fpath, err)
+					continue
+				}
+				for _, bower := range bowers {
+					bowed <- bower.StructureBow(lib)
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(bowed)
+	}()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	oldw := bow.NewOldStyleWriter(out)
+	defer oldw.Flush()
+	for b := range bowed {
+		switch flagFormat {
+		case "tsv":
+			freqs := make([]string, len(b.Bow.Freqs))
+			for i, f := range b.Bow.Freqs {
+				freqs[i] = fmt.Sprintf("%g", f)
+			}
+			fmt.Fprintf(out, "%s\t%s\n", b.Id, strings.Join(freqs, "\t"))
+		case "json":
+			err := enc.Encode(struct {
+				Id    string
+				Freqs []float32
+			}{b.Id, b.Bow.Freqs})
+			util.Assert(err, "Could not write BOW for '%s'", b.Id)
+		case "old":
+			util.Assert(oldw.Write(b), "Could not write BOW for '%s'", b.Id)
+		}
+	}
+}
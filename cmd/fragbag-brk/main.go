@@ -0,0 +1,81 @@
+// fragbag-brk converts between the fragment library format of the original
+// Fragbag program (usually with a '.brk' extension) and the format used by
+// the fragbag package.
+//
+// Usage:
+//
+//	fragbag-brk [-binary] import name brk-file out-frag-lib-file
+//	fragbag-brk export frag-lib-file out-brk-file
+//
+// The import command reads a structure fragment library in the original
+// format and saves it with the name given. When the '-binary' flag is set,
+// the library is saved in the compact binary format instead of JSON. The
+// export command writes a structure fragment library in the original format.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+var flagBinary = false
+
+func init() {
+	log.SetFlags(0)
+
+	flag.BoolVar(&flagBinary, "binary", flagBinary,
+		"When set, imported libraries are saved in binary instead of JSON.")
+
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func usage() {
+	log.Printf("Usage: %s [-binary] import name brk-file out-frag-lib-file\n"+
+		"       %s export frag-lib-file out-brk-file\n",
+		os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	switch {
+	case flag.NArg() == 4 && flag.Arg(0) == "import":
+		importBrk(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+	case flag.NArg() == 3 && flag.Arg(0) == "export":
+		exportBrk(flag.Arg(1), flag.Arg(2))
+	default:
+		flag.Usage()
+	}
+}
+
+func importBrk(name, brkPath, libPath string) {
+	r, err := util.Open(brkPath)
+	util.Assert(err, "Could not open '%s'", brkPath)
+	defer r.Close()
+
+	lib, err := fragbag.OpenBrk(r, name)
+	util.Assert(err, "Could not read '%s'", brkPath)
+
+	out, err := os.Create(libPath)
+	util.Assert(err, "Could not create '%s'", libPath)
+	save := fragbag.Save
+	if flagBinary {
+		save = fragbag.SaveBinary
+	}
+	util.Assert(save(out, lib), "Could not write '%s'", libPath)
+	util.Assert(out.Close(), "Could not write '%s'", libPath)
+}
+
+func exportBrk(libPath, brkPath string) {
+	lib := util.StructureLibrary(libPath)
+
+	out, err := os.Create(brkPath)
+	util.Assert(err, "Could not create '%s'", brkPath)
+	util.Assert(fragbag.SaveBrk(out, lib), "Could not write '%s'", brkPath)
+	util.Assert(out.Close(), "Could not write '%s'", brkPath)
+}
@@ -0,0 +1,198 @@
+// fragbag-inspect prints a summary of a fragment library or a BOW database.
+//
+// Usage:
+//
+//	fragbag-inspect [flags] frag-lib-file [frag-num ...]
+//	fragbag-inspect -db [flags] bowdb-file
+//
+// In library mode, the summary includes the full chain of tags of the
+// library, its name, number of fragments, fragment size and weights (if the
+// library is weighted). If any fragment numbers are given, the string
+// representation of each fragment is printed too.
+//
+// In database mode, the summary of the embedded fragment library is printed
+// along with the database's manifest, the number of entries, statistics about
+// the size of each BOW and a histogram of how often each fragment is used.
+// The ids of every entry can be printed with the '-ids' flag.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bowdb"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+var (
+	flagDB  = false
+	flagIds = false
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.BoolVar(&flagDB, "db", flagDB,
+		"When set, the file given is read as a BOW database.")
+	flag.BoolVar(&flagIds, "ids", flagIds,
+		"When set, the id of every entry in a BOW database is printed.")
+
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func usage() {
+	log.Printf("Usage: %s [flags] frag-lib-file [frag-num ...]\n"+
+		"       %s -db [flags] bowdb-file\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	if flag.NArg() < 1 {
+		flag.Usage()
+	}
+	if flagDB {
+		if flag.NArg() != 1 {
+			flag.Usage()
+		}
+		inspectDB(flag.Arg(0))
+		return
+	}
+
+	lib := util.Library(flag.Arg(0))
+	printLibrary(lib, "")
+	for _, arg := range flag.Args()[1:] {
+		fragNum, err := strconv.Atoi(arg)
+		util.Assert(err, "Could not parse fragment number '%s'", arg)
+		if fragNum < 0 || fragNum >= lib.Size() {
+			log.Fatalf("Fragment number %d is not in the range [0, %d).",
+				fragNum, lib.Size())
+		}
+		fmt.Printf("\n%s\n", lib.FragmentString(fragNum))
+	}
+}
+
+// printLibrary prints a summary of the library given. Every line is prefixed
+// with the indent given.
+func printLibrary(lib fragbag.Library, indent string) {
+	kinds := make([]string, 0, 2)
+	if fragbag.IsStructure(lib) {
+		kinds = append(kinds, "structure")
+	}
+	if fragbag.IsSequence(lib) {
+		kinds = append(kinds, "sequence")
+	}
+
+	fmt.Printf("%sTags: %s\n", indent, strings.Join(tags(lib), ", "))
+	fmt.Printf("%sName: %s\n", indent, lib.Name())
+	fmt.Printf("%sKind: %s\n", indent, strings.Join(kinds, ", "))
+	fmt.Printf("%sSize: %d\n", indent, lib.Size())
+	fmt.Printf("%sFragment size: %d\n", indent, lib.FragmentSize())
+	fmt.Printf("%sDescription: %s\n", indent, lib)
+
+	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
+		weights := make([]string, lib.Size())
+		for i := range weights {
+			weights[i] = fmt.Sprintf("%d:%g", i, wlib.AddWeights(i, 1))
+		}
+		fmt.Printf("%sWeights: %s\n", indent, strings.Join(weights, " "))
+	}
+	if plib, ok := lib.(fragbag.PairedLibrary); ok {
+		fmt.Printf("%sStructure library:\n", indent)
+		printLibrary(plib.StructureLibrary(), indent+"\t")
+		fmt.Printf("%sSequence library:\n", indent)
+		printLibrary(plib.SequenceLibrary(), indent+"\t")
+	}
+	if sub := lib.SubLibrary(); sub != nil {
+		fmt.Printf("%sSub library:\n", indent)
+		printLibrary(sub, indent+"\t")
+	}
+}
+
+// tags returns the full chain of tags of the library given, which is the
+// same chain of tags used when the library is saved.
+func tags(lib fragbag.Library) []string {
+	if sub := lib.SubLibrary(); sub != nil {
+		return append([]string{lib.Tag()}, tags(sub)...)
+	}
+	return []string{lib.Tag()}
+}
+
+// inspectDB prints a summary of the BOW database at the path given.
+func inspectDB(fpath string) {
+	db, err := bowdb.Open(fpath)
+	util.Assert(err, "Could not open BOW database '%s'", fpath)
+	defer db.Close()
+
+	entries, err := db.ReadAll()
+	util.Assert(err, "Could not read BOW database '%s'", fpath)
+
+	m := db.Manifest()
+	fmt.Printf("Database: %s\n", db.Name)
+	fmt.Printf("Format version: %d\n", m.Version)
+	if !m.Created.IsZero() {
+		fmt.Printf("Created: %s\n", m.Created)
+	}
+	if len(m.Codec) > 0 {
+		fmt.Printf("Codec: %s\n", m.Codec)
+	}
+	for _, key := range sortedKeys(m.Params) {
+		fmt.Printf("Parameter %s: %s\n", key, m.Params[key])
+	}
+	fmt.Printf("Library:\n")
+	printLibrary(db.Lib, "\t")
+	fmt.Printf("Entries: %d\n", len(entries))
+
+	// Compute the size of each BOW (i.e., the sum of its frequencies) and
+	// the number of entries that use each fragment.
+	used := make([]int, db.Lib.Size())
+	totals := make([]float64, db.Lib.Size())
+	minSize, maxSize, sumSize := math.Inf(1), math.Inf(-1), 0.0
+	for _, entry := range entries {
+		size := 0.0
+		for i, freq := range entry.Bow.Freqs {
+			if freq != 0 {
+				used[i]++
+				totals[i] += float64(freq)
+			}
+			size += float64(freq)
+		}
+		minSize = math.Min(minSize, size)
+		maxSize = math.Max(maxSize, size)
+		sumSize += size
+	}
+	if len(entries) > 0 {
+		fmt.Printf("BOW size: min %g, max %g, mean %g\n",
+			minSize, maxSize, sumSize/float64(len(entries)))
+	}
+
+	fmt.Printf("Fragment usage (fragment, entries, total frequency):\n")
+	for i := range used {
+		fmt.Printf("\t%d\t%d\t%g\n", i, used[i], totals[i])
+	}
+
+	if flagIds {
+		fmt.Printf("Ids:\n")
+		for _, entry := range entries {
+			fmt.Printf("\t%s\n", entry.Id)
+		}
+	}
+}
+
+// sortedKeys returns the keys of the map given in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
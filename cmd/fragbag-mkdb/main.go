@@ -0,0 +1,302 @@
+// fragbag-mkdb creates a BOW database from PDB, mmCIF or FASTA files with
+// a fragment library.
+//
+// Usage:
+//
+//	fragbag-mkdb [flags] frag-lib-file bowdb-file (pdb|cif|fasta|dir) ...
+//
+// Directories are searched recursively. Files ending in '.cif' are read as
+// mmCIF files, files ending in '.fasta', '.fas', '.fa' or '.faa' are read as
+// FASTA files and every other file is read as a PDB file. (Any of these may
+// be compressed with gzip, in which case they must end with '.gz'.)
+//
+// Structures can only be added with a structure fragment library and
+// sequences can only be added with a sequence fragment library. (A paired
+// fragment library can add both.) Files that cannot be read are reported on
+// stderr and skipped.
+//
+// The BOWs in the database can be compressed with gzip by using the
+// '-codec gzip' flag. The '-mappable' flag adds a section to the database
+// that fragbag-search can memory map with its '-mmap' flag, which makes
+// opening large databases much faster.
+//
+// Instead of a BOW for every chain, a BOW for every domain of every PDB file
+// can be computed by giving a file of domain definitions with the '-domains'
+// flag. Its format is chosen with the '-domain-format' flag: 'scop' for SCOP
+// classification files (dir.cla.scop.txt), 'cath' for CATH domain boundary
+// files in the 'cath-b' format or 'ecod' for ECOD domain files (domains.txt).
+//
+// For predicted structures, which store per-residue confidence (e.g., pLDDT)
+// in the B-factor column, the '-confidence' flag skips fragments containing
+// residues with a B-factor below '-confidence-threshold'. With
+// '-confidence-scale', the remaining fragments are also down-weighted by
+// their confidence. The fraction of each chain that contributed to its BOW
+// is recorded in its meta data.
+//
+// Progress is shown on stderr with the '-progress' flag. If the program is
+// interrupted, the incomplete database is removed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/fragbag/bowdb"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+var (
+	flagModels   = false
+	flagEnsemble = false
+	flagCpu      = runtime.NumCPU()
+	flagCodec    = ""
+	flagMap      = false
+	flagProg     = false
+
+	flagDomains      = ""
+	flagDomainFormat = "scop"
+
+	flagConf          = false
+	flagConfThreshold = 70.0
+	flagConfScale     = 0.0
+
+	// domains is keyed by PDB entry id. It is nil when the '-domains' flag
+	// isn't set.
+	domains map[string][]bow.Domain
+
+	// flagMode is the mode corresponding to the '-models' and '-ensemble'
+	// flags.
+	flagMode bow.ModelMode
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.BoolVar(&flagModels, "models", flagModels,
+		"When set, a BOW is computed for every model of every chain "+
+			"instead of only the first model of every chain.")
+	flag.BoolVar(&flagEnsemble, "ensemble", flagEnsemble,
+		"When set, the BOW of every chain is the average of the BOWs of "+
+			"all of its models, as for NMR ensembles.")
+	flag.IntVar(&flagCpu, "cpu", flagCpu,
+		"The number of files to process in parallel.")
+	flag.StringVar(&flagCodec, "codec", flagCodec,
+		"When set, BOWs in the database are compressed with the codec "+
+			"named. Only 'gzip' is available.")
+	flag.BoolVar(&flagMap, "mappable", flagMap,
+		"When set, a fixed layout section is added to the database so that "+
+			"it can be memory mapped and searched in place.")
+	flag.BoolVar(&flagProg, "progress", flagProg,
+		"When set, the number of files processed is shown on stderr.")
+	flag.StringVar(&flagDomains, "domains", flagDomains,
+		"When set, a BOW is computed for every domain defined in this file "+
+			"instead of every chain in PDB files.")
+	flag.StringVar(&flagDomainFormat, "domain-format", flagDomainFormat,
+		"The format of the file given with '-domains': 'scop', 'cath' or "+
+			"'ecod'.")
+	flag.BoolVar(&flagConf, "confidence", flagConf,
+		"When set, residues in PDB files are weighted by the confidence "+
+			"in their B-factor column, as in predicted structures.")
+	flag.Float64Var(&flagConfThreshold, "confidence-threshold",
+		flagConfThreshold,
+		"With '-confidence', fragments with a residue whose B-factor is "+
+			"below this threshold are skipped.")
+	flag.Float64Var(&flagConfScale, "confidence-scale", flagConfScale,
+		"With '-confidence', when positive, fragments are down-weighted by "+
+			"the smallest B-factor of their residues divided by this scale "+
+			"(e.g., 100 for pLDDT).")
+
+	flag.Usage = usage
+	flag.Parse()
+	flagMode = util.ModelMode(flagModels, flagEnsemble)
+
+	runtime.GOMAXPROCS(flagCpu)
+}
+
+func usage() {
+	log.Printf("Usage: %s [flags] frag-lib-file bowdb-file "+
+		"(pdb|cif|fasta|dir) ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	if flag.NArg() < 3 {
+		flag.Usage()
+	}
+	exclusive := 0
+	for _, set := range []bool{
+		len(flagDomains) > 0, flagConf, flagMode != bow.FirstModel,
+	} {
+		if set {
+			exclusive++
+		}
+	}
+	if exclusive > 1 {
+		log.Fatalf("At most one of '-domains', '-confidence' and " +
+			"'-models' (or '-ensemble') may be set.")
+	}
+
+	var codec bowdb.Codec
+	if len(flagCodec) > 0 {
+		var err error
+		codec, err = bowdb.LookupCodec(flagCodec)
+		util.Assert(err, "Invalid codec")
+	}
+
+	lib := util.Library(flag.Arg(0))
+	if len(flagDomains) > 0 {
+		domains = util.Domains(flagDomains, flagDomainFormat)
+	}
+	db, err := bowdb.CreateCodec(lib, flag.Arg(1), codec)
+	util.Assert(err, "Could not create BOW database '%s'", flag.Arg(1))
+	db.Params = map[string]string{
+		"frag-lib": flag.Arg(0),
+		"models":   strconv.FormatBool(flagModels),
+		"ensemble": strconv.FormatBool(flagEnsemble),
+	}
+	if len(flagDomains) > 0 {
+		db.Params["domains"] = flagDomains
+		db.Params["domain-format"] = flagDomainFormat
+	}
+	if flagConf {
+		db.Params["confidence-threshold"] = fmt.Sprint(flagConfThreshold)
+		db.Params["confidence-scale"] = fmt.Sprint(flagConfScale)
+	}
+	db.Mappable = flagMap
+
+	// Stop adding files on an interrupt and remove the incomplete database.
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	var paths []string
+	for fpath := range util.Files(flag.Args()[2:]...) {
+		paths = append(paths, fpath)
+	}
+	files := make(chan string)
+	go func() {
+		defer close(files)
+		for _, fpath := range paths {
+			select {
+			case files <- fpath:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var progress bow.Progress
+	if flagProg {
+		progress = func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\rProcessed %d/%d files.", done, total)
+		}
+	}
+	done := 0
+	doneLock := new(sync.Mutex)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < flagCpu; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fpath := range files {
+				err := addFile(ctx, db, lib, fpath)
+				if err != nil && err != ctx.Err() {
+					log.Printf("Could not add '%s': %s", fpath, err)
+				}
+
+				doneLock.Lock()
+				done++
+				progress.Report(done, len(paths))
+				doneLock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if flagProg {
+		fmt.Fprintln(os.Stderr)
+	}
+
+	util.Assert(db.Close(), "Could not close BOW database '%s'", flag.Arg(1))
+	if ctx.Err() != nil {
+		util.Assert(os.Remove(flag.Arg(1)),
+			"Could not remove incomplete BOW database '%s'", flag.Arg(1))
+		log.Fatalf("Interrupted. Removed incomplete BOW database '%s'.",
+			flag.Arg(1))
+	}
+}
+
+// addFile computes BOWs for every structure or sequence in the file given
+// and adds them to the database. Adding stops if the context is cancelled.
+func addFile(
+	ctx context.Context,
+	db *bowdb.DB,
+	lib fragbag.Library,
+	fpath string,
+) error {
+	if util.IsFasta(fpath) {
+		if !fragbag.IsSequence(lib) {
+			return fmt.Errorf("Sequences require a sequence fragment library.")
+		}
+		seqs, err := util.Sequences(fpath)
+		if err != nil {
+			return err
+		}
+		seqLib := lib.(fragbag.SequenceLibrary)
+		for _, s := range seqs {
+			b := bow.BowerFromSequence(s).SequenceBow(seqLib)
+			b.Meta.Source = fpath
+			if err := db.AddContext(ctx, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !fragbag.IsStructure(lib) {
+		return fmt.Errorf("Structures require a structure fragment " +
+			"library.")
+	}
+	var bowers []bow.StructureBower
+	var err error
+	switch {
+	case domains != nil:
+		bowers, err = util.DomainBowers(fpath, domains)
+	case flagConf:
+		conf := bow.Confidence{
+			Threshold: flagConfThreshold,
+			Scale:     flagConfScale,
+		}
+		bowers, err = util.ConfidentBowers(fpath, conf)
+	default:
+		bowers, err = util.StructureBowers(fpath, flagMode)
+	}
+	if err != nil {
+		return err
+	}
+	structLib := lib.(fragbag.StructureLibrary)
+	for _, bower := range bowers {
+		b := bower.StructureBow(structLib)
+		if len(b.Meta.Source) == 0 {
+			b.Meta.Source = fpath
+		}
+		if err := db.AddContext(ctx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
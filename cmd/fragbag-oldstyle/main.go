@@ -0,0 +1,127 @@
+// fragbag-oldstyle converts between the output of the original Fragbag
+// program and BOW databases.
+//
+// Usage:
+//
+//	fragbag-oldstyle import frag-lib-file bowdb-file oldstyle-file ...
+//	fragbag-oldstyle export bowdb-file
+//
+// The import command creates a new BOW database with the fragment library
+// given from every id/BOW line in the old style files given. The fragment
+// library must be the library used to produce the old style output. If it is
+// a weighted library, its weights are applied to every BOW. If an error
+// occurs, the incomplete database is removed.
+//
+// The export command writes every entry in a BOW database to stdout in the
+// old style format (see bow.OldStyleReader). Since the old style format can
+// only represent integral frequencies, exporting fails on the first BOW with
+// a fractional frequency (like BOWs computed with a weighted fragment
+// library).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/fragbag/bowdb"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func usage() {
+	log.Printf("Usage: %s import frag-lib-file bowdb-file oldstyle-file ...\n"+
+		"       %s export bowdb-file\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	if flag.NArg() < 1 {
+		flag.Usage()
+	}
+	switch flag.Arg(0) {
+	case "import":
+		if flag.NArg() < 4 {
+			flag.Usage()
+		}
+		importOldStyle(flag.Arg(1), flag.Arg(2), flag.Args()[3:])
+	case "export":
+		if flag.NArg() != 2 {
+			flag.Usage()
+		}
+		exportOldStyle(flag.Arg(1))
+	default:
+		flag.Usage()
+	}
+}
+
+func importOldStyle(libPath, dbPath string, oldPaths []string) {
+	lib := util.Library(libPath)
+	db, err := bowdb.Create(lib, dbPath)
+	util.Assert(err, "Could not create BOW database '%s'", dbPath)
+
+	if err := addOldStyle(db, lib, oldPaths); err != nil {
+		db.Close()
+		util.Assert(os.Remove(dbPath),
+			"Could not remove incomplete BOW database '%s'", dbPath)
+		log.Fatalf("%s. Removed incomplete BOW database '%s'.", err, dbPath)
+	}
+	util.Assert(db.Close(), "Could not close BOW database '%s'", dbPath)
+}
+
+// addOldStyle adds every BOW in the old style files given to the database.
+// If the library is weighted, its weights are applied to each BOW first,
+// since old style files store raw frequencies.
+func addOldStyle(
+	db *bowdb.DB,
+	lib fragbag.Library,
+	oldPaths []string,
+) error {
+	wlib, weighted := lib.(fragbag.WeightedLibrary)
+	for _, fpath := range oldPaths {
+		f, err := util.Open(fpath)
+		if err != nil {
+			return fmt.Errorf("Could not open '%s': %s", fpath, err)
+		}
+		bs, err := bow.NewOldStyleReader(f, lib.Size()).ReadAll()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Could not read '%s': %s", fpath, err)
+		}
+
+		for _, b := range bs {
+			if weighted {
+				b.Bow = b.Bow.Weighted(wlib)
+			}
+			if err := db.Add(b); err != nil {
+				return fmt.Errorf("Could not add '%s': %s", b.Id, err)
+			}
+		}
+	}
+	return nil
+}
+
+func exportOldStyle(dbPath string) {
+	db, err := bowdb.Open(dbPath)
+	util.Assert(err, "Could not open BOW database '%s'", dbPath)
+	defer db.Close()
+
+	entries, err := db.ReadAll()
+	util.Assert(err, "Could not read BOW database '%s'", dbPath)
+
+	w := bow.NewOldStyleWriter(os.Stdout)
+	for _, entry := range entries {
+		util.Assert(w.Write(entry), "Could not write '%s'", entry.Id)
+	}
+	util.Assert(w.Flush(), "Could not write to stdout")
+}
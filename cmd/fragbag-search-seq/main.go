@@ -0,0 +1,87 @@
+// fragbag-search-seq searches a BOW database of structures with sequence
+// queries. The database must have been made with a paired fragment library,
+// and BOWs for each query are computed with the sequence library that was
+// paired with the structure library (see bowdb.DB.SearchCrossModal).
+//
+// Usage:
+//
+//	fragbag-search-seq [flags] bowdb-file seq-frag-lib (fasta|a3m)-file ...
+//
+// Files with a '.a3m' or '.a2m' extension are read as multiple sequence
+// alignments, whose first sequence is the query. The BOW of the query is
+// computed from the profile of the alignment (see bow.BowerFromAlignment).
+//
+// Results are written to stdout as tab separated values with the columns:
+// query id, hit id, cosine distance and euclidean distance.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+var flagSearch *util.SearchFlags
+
+func init() {
+	log.SetFlags(0)
+
+	flagSearch = util.NewSearchFlags()
+
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func usage() {
+	log.Printf("Usage: %s [flags] bowdb-file seq-frag-lib "+
+		"(fasta|a3m)-file ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	if flag.NArg() < 3 {
+		flag.Usage()
+	}
+
+	db := flagSearch.DB(flag.Arg(0))
+	defer db.Close()
+
+	seqLib := util.SequenceLibrary(flag.Arg(1))
+	opts := flagSearch.Options()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	search := func(qid string, bower bow.SequenceBower) {
+		results, err := db.SearchCrossModal(opts, seqLib, bower)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, r := range results {
+			fmt.Fprintf(out, "%s\t%s\t%f\t%f\n",
+				qid, r.Id, r.Cosine, r.Euclid)
+		}
+	}
+	for _, fpath := range flag.Args()[2:] {
+		if util.IsAlignment(fpath) {
+			msa, err := util.Alignment(fpath)
+			util.Assert(err, "Could not read alignment '%s'", fpath)
+			bower, err := bow.BowerFromAlignment(msa)
+			util.Assert(err, "Could not read alignment '%s'", fpath)
+			search(strings.Fields(msa[0].Name)[0], bower)
+			continue
+		}
+
+		seqs, err := util.Sequences(fpath)
+		util.Assert(err, "Could not read FASTA file '%s'", fpath)
+		for _, s := range seqs {
+			search(strings.Fields(s.Name)[0], bow.BowerFromSequence(s))
+		}
+	}
+}
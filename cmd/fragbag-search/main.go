@@ -0,0 +1,203 @@
+// fragbag-search searches a BOW database with query structures or sequences.
+// BOWs for each query are computed with the fragment library embedded in the
+// database.
+//
+// Usage:
+//
+//	fragbag-search [flags] bowdb-file (pdb|cif|fasta|a3m|dir) ...
+//
+// Query files are read just like they are by fragbag-mkdb. Structure queries
+// require a database with a structure fragment library and sequence queries
+// require a database with a sequence fragment library.
+//
+// Files with a '.a3m' or '.a2m' extension are read as multiple sequence
+// alignments, whose first sequence is the query. The BOW of the query is
+// computed from the profile of the alignment (see bow.BowerFromAlignment).
+//
+// Results are written to stdout in one of two formats, chosen with the
+// '-format' flag:
+//
+//	tsv   One line per hit with the columns: query id, hit id, cosine
+//	      distance and euclidean distance.
+//	json  One JSON object per query with "Query" and "Results" keys. Each
+//	      result has "Id", "Meta", "Cosine" and "Euclid" keys, where
+//	      "Meta" is the meta data of the hit (see bow.Metadata).
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/fragbag/bowdb"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+var (
+	flagFormat   = "tsv"
+	flagModels   = false
+	flagEnsemble = false
+	flagSearch   *util.SearchFlags
+
+	// flagMode is the mode corresponding to the '-models' and '-ensemble'
+	// flags.
+	flagMode bow.ModelMode
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&flagFormat, "format", flagFormat,
+		"The output format: 'tsv' or 'json'.")
+	flag.BoolVar(&flagModels, "models", flagModels,
+		"When set, every model of every chain is used as a query instead "+
+			"of only the first model of every chain.")
+	flag.BoolVar(&flagEnsemble, "ensemble", flagEnsemble,
+		"When set, the query for every chain is the average of the BOWs of "+
+			"all of its models, as for NMR ensembles.")
+	flagSearch = util.NewSearchFlags()
+
+	flag.Usage = usage
+	flag.Parse()
+	flagMode = util.ModelMode(flagModels, flagEnsemble)
+}
+
+func usage() {
+	log.Printf("Usage: %s [flags] bowdb-file (pdb|cif|fasta|a3m|dir) ...\n",
+		os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// jsonResult is the JSON representation of a single hit.
+type jsonResult struct {
+	Id             string
+	Meta           bow.Metadata
+	Cosine, Euclid float64
+}
+
+// jsonQuery is the JSON representation of all hits for a single query.
+type jsonQuery struct {
+	Query   string
+	Results []jsonResult
+}
+
+func main() {
+	if flag.NArg() < 2 {
+		flag.Usage()
+	}
+	switch flagFormat {
+	case "tsv", "json":
+	default:
+		log.Fatalf("Unrecognized output format '%s'.", flagFormat)
+	}
+
+	db := flagSearch.DB(flag.Arg(0))
+	defer db.Close()
+
+	opts := flagSearch.Options()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	for fpath := range util.Files(flag.Args()[1:]...) {
+		queries, err := search(db, opts, fpath)
+		if err != nil {
+			log.Printf("Could not search with '%s': %s", fpath, err)
+			continue
+		}
+		for _, q := range queries {
+			switch flagFormat {
+			case "tsv":
+				for _, r := range q.Results {
+					fmt.Fprintf(out, "%s\t%s\t%f\t%f\n",
+						q.Query, r.Id, r.Cosine, r.Euclid)
+				}
+			case "json":
+				util.Assert(enc.Encode(q),
+					"Could not write results for '%s'", q.Query)
+			}
+		}
+	}
+}
+
+// search runs a search for every structure, sequence or alignment in the file
+// given.
+func search(
+	db *bowdb.DB,
+	opts bowdb.SearchOptions,
+	fpath string,
+) ([]jsonQuery, error) {
+	var queries []jsonQuery
+	add := func(query bow.Bowed) error {
+		results, err := db.Search(opts, query)
+		if err != nil {
+			return err
+		}
+		q := jsonQuery{query.Id, make([]jsonResult, len(results))}
+		for i, r := range results {
+			q.Results[i] = jsonResult{r.Id, r.Meta, r.Cosine, r.Euclid}
+		}
+		queries = append(queries, q)
+		return nil
+	}
+
+	if util.IsFasta(fpath) || util.IsAlignment(fpath) {
+		if !fragbag.IsSequence(db.Lib) {
+			return nil, fmt.Errorf("Sequence queries require a database "+
+				"with a sequence fragment library, but '%s' has a structure "+
+				"fragment library.", db)
+		}
+		lib := db.Lib.(fragbag.SequenceLibrary)
+
+		if util.IsAlignment(fpath) {
+			msa, err := util.Alignment(fpath)
+			if err != nil {
+				return nil, err
+			}
+			bower, err := bow.BowerFromAlignment(msa)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(bower.SequenceBow(lib)); err != nil {
+				return nil, err
+			}
+			return queries, nil
+		}
+
+		seqs, err := util.Sequences(fpath)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range seqs {
+			b := bow.BowerFromSequence(s).SequenceBow(lib)
+			if err := add(b); err != nil {
+				return nil, err
+			}
+		}
+		return queries, nil
+	}
+
+	if !fragbag.IsStructure(db.Lib) {
+		return nil, fmt.Errorf("Structure queries require a database "+
+			"with a structure fragment library, but '%s' has a sequence "+
+			"fragment library.", db)
+	}
+	lib := db.Lib.(fragbag.StructureLibrary)
+
+	bowers, err := util.StructureBowers(fpath, flagMode)
+	if err != nil {
+		return nil, err
+	}
+	for _, bower := range bowers {
+		if err := add(bower.StructureBow(lib)); err != nil {
+			return nil, err
+		}
+	}
+	return queries, nil
+}
@@ -0,0 +1,82 @@
+// fragbag-server serves searches of one or more BOW databases over HTTP,
+// responding with JSON. See the documentation of the bowserver package for
+// the endpoints and parameters available.
+//
+// Usage:
+//
+//	fragbag-server [flags] bowdb-file ...
+//
+// Each database is named by the base name of its file path. Databases are
+// reloaded when their files change, which is checked at the interval given
+// by the '-reload' flag.
+//
+// To update a database while it is served, write the new database to another
+// path on the same file system and rename it over the old file, e.g.:
+//
+//	fragbag-mkdb frag-lib-file proteins.bowdb.new pdb-dir
+//	mv proteins.bowdb.new proteins.bowdb
+//
+// Never overwrite a database file in place. In particular, with the '-mmap'
+// flag, a database that is modified in place makes the server crash.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/TuftsBCB/fragbag/bowserver"
+	"github.com/TuftsBCB/fragbag/cmd/internal/util"
+)
+
+var (
+	flagAddr   = ":8080"
+	flagMmap   = false
+	flagReload = 10 * time.Second
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&flagAddr, "addr", flagAddr,
+		"The address to listen on.")
+	flag.BoolVar(&flagMmap, "mmap", flagMmap,
+		"When set, databases are memory mapped and searched in place "+
+			"instead of being read into memory. They must have been created "+
+			"with the '-mappable' flag. Database files must then only be "+
+			"replaced by renaming new files over them.")
+	flag.DurationVar(&flagReload, "reload", flagReload,
+		"The interval at which BOW database files are checked for changes. "+
+			"When zero, databases are never reloaded.")
+
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func usage() {
+	log.Printf("Usage: %s [flags] bowdb-file ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	if flag.NArg() < 1 {
+		flag.Usage()
+	}
+
+	s, err := bowserver.NewServer(flag.Args(), flagMmap)
+	util.Assert(err, "Could not start server")
+	defer s.Close()
+
+	if flagReload > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.Watch(ctx, flagReload)
+	}
+
+	log.Printf("Listening on %s", flagAddr)
+	util.Assert(http.ListenAndServe(flagAddr, s), "Could not serve")
+}
@@ -0,0 +1,52 @@
+// fragbag-verify checks the integrity of BOW databases.
+//
+// Usage:
+//
+//	fragbag-verify bowdb-file ...
+//
+// Every database given is checked against the checksums and entry count in
+// its manifest, and the framing of every record in the database is validated.
+// The result of each check is printed on its own line. If any database fails
+// verification, the program exits with a non-zero status.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/TuftsBCB/fragbag/bowdb"
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func usage() {
+	log.Printf("Usage: %s bowdb-file ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	if flag.NArg() < 1 {
+		flag.Usage()
+	}
+
+	failed := false
+	for _, fpath := range flag.Args() {
+		if err := bowdb.Verify(fpath); err != nil {
+			fmt.Printf("%s: FAILED: %s\n", fpath, err)
+			failed = true
+		} else {
+			fmt.Printf("%s: OK\n", fpath)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
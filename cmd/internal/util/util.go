@@ -0,0 +1,491 @@
+// Package util provides helper functions shared by the fragbag commands.
+// Functions in this package that open files required by a command (like
+// fragment libraries) quit the program with an error message on failure.
+package util
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/TuftsBCB/fragbag"
+	"github.com/TuftsBCB/fragbag/bow"
+	"github.com/TuftsBCB/fragbag/bowdb"
+	"github.com/TuftsBCB/io/fasta"
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/TuftsBCB/seq"
+)
+
+// Assert quits the program with the formatted message and the error given
+// if the error is not nil.
+func Assert(err error, format string, v ...interface{}) {
+	if err != nil {
+		log.Fatalf("%s: %s", fmt.Sprintf(format, v...), err)
+	}
+}
+
+// Library opens the fragment library at the path given.
+func Library(fpath string) fragbag.Library {
+	f, err := os.Open(fpath)
+	Assert(err, "Could not open fragment library '%s'", fpath)
+	defer f.Close()
+
+	lib, err := fragbag.Open(f)
+	Assert(err, "Could not read fragment library '%s'", fpath)
+	return lib
+}
+
+// StructureLibrary opens the fragment library at the path given and quits
+// the program if it is not a structure library.
+func StructureLibrary(fpath string) fragbag.StructureLibrary {
+	lib := Library(fpath)
+	if !fragbag.IsStructure(lib) {
+		log.Fatalf("Fragment library '%s' is not a structure library.", fpath)
+	}
+	return lib.(fragbag.StructureLibrary)
+}
+
+// SequenceLibrary opens the fragment library at the path given and quits
+// the program if it is not a sequence library.
+func SequenceLibrary(fpath string) fragbag.SequenceLibrary {
+	lib := Library(fpath)
+	if !fragbag.IsSequence(lib) {
+		log.Fatalf("Fragment library '%s' is not a sequence library.", fpath)
+	}
+	return lib.(fragbag.SequenceLibrary)
+}
+
+// Files returns a channel that is sent every regular file in the paths given.
+// Directories are searched recursively. Paths that cannot be read are
+// reported and skipped. The channel is closed once all files have been sent.
+func Files(paths ...string) <-chan string {
+	files := make(chan string)
+	go func() {
+		defer close(files)
+		for _, fpath := range paths {
+			err := filepath.Walk(fpath,
+				func(p string, info os.FileInfo, err error) error {
+					if err != nil {
+						log.Printf("Could not read '%s': %s", p, err)
+						return nil
+					}
+					if info.Mode().IsRegular() {
+						files <- p
+					}
+					return nil
+				})
+			if err != nil {
+				log.Printf("Could not read '%s': %s", fpath, err)
+			}
+		}
+	}()
+	return files
+}
+
+// IsCif returns true if the file path given looks like an mmCIF file.
+func IsCif(fpath string) bool {
+	return hasExt(fpath, ".cif")
+}
+
+// IsFasta returns true if the file path given looks like a FASTA file.
+func IsFasta(fpath string) bool {
+	return hasExt(fpath, ".fasta", ".fas", ".fa", ".faa")
+}
+
+// IsAlignment returns true if the file path given looks like a multiple
+// sequence alignment in A3M or A2M format.
+func IsAlignment(fpath string) bool {
+	return hasExt(fpath, ".a3m", ".a2m")
+}
+
+// hasExt returns true if the file path has any of the extensions given,
+// ignoring a '.gz' suffix.
+func hasExt(fpath string, exts ...string) bool {
+	fpath = strings.TrimSuffix(strings.ToLower(fpath), ".gz")
+	for _, ext := range exts {
+		if strings.HasSuffix(fpath, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// StructureBowers reads the PDB or mmCIF file given and returns a structure
+// bower for every protein chain in the file, with the models of each chain
+// chosen by the mode given. (See bow.PdbBowers and bow.CifBowers.)
+//
+// Files are read as mmCIF files if IsCif returns true. Otherwise, they are
+// read as PDB files. The resolution of the file is recorded in the meta data
+// of every BOW. (See bow.ReadResolution.)
+func StructureBowers(
+	fpath string,
+	mode bow.ModelMode,
+) ([]bow.StructureBower, error) {
+	if IsCif(fpath) {
+		bowers, err := cifBowers(fpath, mode)
+		if err != nil {
+			return nil, err
+		}
+		return withResolution(fpath, bowers)
+	}
+
+	entry, err := pdb.ReadPDB(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return withResolution(fpath, bow.PdbBowers(entry, mode))
+}
+
+// withResolution reads the resolution of the PDB or mmCIF file given and
+// records it in the meta data of the BOWs of every bower given.
+func withResolution(
+	fpath string,
+	bowers []bow.StructureBower,
+) ([]bow.StructureBower, error) {
+	r, err := Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	res, err := bow.ReadResolution(r)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read resolution: %s", err)
+	}
+	if res == 0 {
+		return bowers, nil
+	}
+	for i, b := range bowers {
+		bowers[i] = bow.BowerWithResolution(b, res)
+	}
+	return bowers, nil
+}
+
+// ModelMode returns the mode corresponding to the '-models' and '-ensemble'
+// flags of a command. If both are set, the program quits.
+func ModelMode(models, ensemble bool) bow.ModelMode {
+	switch {
+	case models && ensemble:
+		log.Fatalf("At most one of '-models' and '-ensemble' may be set.")
+	case models:
+		return bow.EveryModel
+	case ensemble:
+		return bow.EnsembleModels
+	}
+	return bow.FirstModel
+}
+
+// ConfidentBowers reads the PDB file given and returns a structure bower for
+// every protein chain in the file, where each residue is weighted by its
+// B-factor according to the confidence given. (See
+// bow.BowerFromConfidentChain.)
+func ConfidentBowers(
+	fpath string,
+	conf bow.Confidence,
+) ([]bow.StructureBower, error) {
+	if IsCif(fpath) {
+		return nil, fmt.Errorf("B-factors can only be read from PDB files.")
+	}
+	entry, err := pdb.ReadPDB(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	bfactors, err := bow.ReadBFactors(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bowers := make([]bow.StructureBower, 0, len(entry.Chains))
+	for _, chain := range entry.Chains {
+		if !chain.IsProtein() {
+			continue
+		}
+		b := bow.BowerFromConfidentChain(chain, bfactors[chain.Ident], conf)
+		bowers = append(bowers, b)
+	}
+	return withResolution(fpath, bowers)
+}
+
+// Domains reads the domain definitions in the file given, which has the
+// format given: 'scop', 'cath' or 'ecod'. (See bow.ReadScopDomains,
+// bow.ReadCathDomains and bow.ReadEcodDomains.) Domains are keyed by the
+// identifier of their PDB entry.
+func Domains(fpath, format string) map[string][]bow.Domain {
+	read := map[string]func(io.Reader) ([]bow.Domain, error){
+		bow.DomainScop: bow.ReadScopDomains,
+		bow.DomainCath: bow.ReadCathDomains,
+		bow.DomainEcod: bow.ReadEcodDomains,
+	}[format]
+	if read == nil {
+		log.Fatalf("Unrecognized domain format '%s'.", format)
+	}
+
+	r, err := Open(fpath)
+	Assert(err, "Could not open domains '%s'", fpath)
+	defer r.Close()
+
+	domains, err := read(r)
+	Assert(err, "Could not read domains '%s'", fpath)
+	byEntry := make(map[string][]bow.Domain)
+	for _, d := range domains {
+		byEntry[d.Entry] = append(byEntry[d.Entry], d)
+	}
+	return byEntry
+}
+
+// DomainBowers reads the PDB file given and returns a structure bower for
+// every domain of its entry in the domains given (see Domains). Domains that
+// cannot be found in the entry are reported on stderr and skipped. An error
+// is returned if the entry has no domains, or if none of them can be found.
+func DomainBowers(
+	fpath string,
+	domains map[string][]bow.Domain,
+) ([]bow.StructureBower, error) {
+	if IsCif(fpath) {
+		return nil, fmt.Errorf("Domains can only be read from PDB files.")
+	}
+	entry, err := pdb.ReadPDB(fpath)
+	if err != nil {
+		return nil, err
+	}
+	ds := domains[strings.ToLower(entry.IdCode)]
+	if len(ds) == 0 {
+		return nil, fmt.Errorf("No domains found for PDB entry '%s'.",
+			entry.IdCode)
+	}
+	bowers := make([]bow.StructureBower, 0, len(ds))
+	for _, d := range ds {
+		bower, err := bow.BowerFromDomain(entry, d)
+		if err != nil {
+			log.Printf("Skipping domain '%s' in '%s': %s", d.Id, fpath, err)
+			continue
+		}
+		bowers = append(bowers, bower)
+	}
+	if len(bowers) == 0 {
+		return nil, fmt.Errorf("None of the %d domains of PDB entry '%s' "+
+			"could be found.", len(ds), entry.IdCode)
+	}
+	return withResolution(fpath, bowers)
+}
+
+func cifBowers(
+	fpath string,
+	mode bow.ModelMode,
+) ([]bow.StructureBower, error) {
+	r, err := Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries, err := pdbx.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	bowers := make([]bow.StructureBower, 0)
+	for _, entry := range entries {
+		bowers = append(bowers, bow.CifBowers(entry, fpath, mode)...)
+	}
+	return bowers, nil
+}
+
+// Sequences reads all sequences in the FASTA file given.
+func Sequences(fpath string) ([]seq.Sequence, error) {
+	r, err := Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return fasta.NewReader(r).ReadAll()
+}
+
+// Alignment reads the multiple sequence alignment in the file given. (See
+// bow.ReadAlignment.)
+func Alignment(fpath string) ([]seq.Sequence, error) {
+	r, err := Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return bow.ReadAlignment(r)
+}
+
+// Open opens the file given for reading. If the file path ends with '.gz',
+// then the file is transparently decompressed.
+func Open(fpath string) (io.ReadCloser, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(fpath, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{gz, f}, nil
+}
+
+// gzipFile closes both the gzip reader and its underlying file.
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (gz gzipFile) Close() error {
+	if err := gz.Reader.Close(); err != nil {
+		gz.f.Close()
+		return err
+	}
+	return gz.f.Close()
+}
+
+// SearchFlags corresponds to command line flags for every search option,
+// along with how the database searched is opened.
+type SearchFlags struct {
+	Limit    int
+	Min, Max float64
+	Sort     string
+	Desc     bool
+	Mmap     bool
+
+	IdPrefix, ExcludeIdPrefix      string
+	IdRegexp, ExcludeIdRegexp      string
+	ExcludeQuery, ExcludeSameEntry bool
+	Meta                           string
+}
+
+// NewSearchFlags registers flags for every search option with the default
+// flag set. Default values are taken from bowdb.SearchDefault.
+func NewSearchFlags() *SearchFlags {
+	f := &SearchFlags{
+		Limit: bowdb.SearchDefault.Limit,
+		Min:   bowdb.SearchDefault.Min,
+		Max:   bowdb.SearchDefault.Max,
+		Sort:  "cosine",
+	}
+	flag.IntVar(&f.Limit, "limit", f.Limit,
+		"The maximum number of results to show per query. "+
+			"Use -1 for no limit.")
+	flag.Float64Var(&f.Min, "min", f.Min,
+		"The minimum distance of a result.")
+	flag.Float64Var(&f.Max, "max", f.Max,
+		"The maximum distance of a result.")
+	flag.StringVar(&f.Sort, "sort", f.Sort,
+		"The metric to sort results by: 'cosine' or 'euclid'.")
+	flag.BoolVar(&f.Desc, "desc", f.Desc,
+		"When set, results are sorted in descending order.")
+	flag.BoolVar(&f.Mmap, "mmap", f.Mmap,
+		"When set, the database is memory mapped and searched in place. "+
+			"It must have been created with the '-mappable' flag.")
+	flag.StringVar(&f.IdPrefix, "id-prefix", f.IdPrefix,
+		"When set, only results with ids starting with one of these comma "+
+			"separated prefixes are shown.")
+	flag.StringVar(&f.ExcludeIdPrefix, "exclude-id-prefix",
+		f.ExcludeIdPrefix,
+		"When set, results with ids starting with one of these comma "+
+			"separated prefixes are not shown.")
+	flag.StringVar(&f.IdRegexp, "id-regexp", f.IdRegexp,
+		"When set, only results with ids matching this regular expression "+
+			"are shown.")
+	flag.StringVar(&f.ExcludeIdRegexp, "exclude-id-regexp",
+		f.ExcludeIdRegexp,
+		"When set, results with ids matching this regular expression "+
+			"are not shown.")
+	flag.BoolVar(&f.ExcludeQuery, "exclude-query", f.ExcludeQuery,
+		"When set, results with the same id as the query are not shown.")
+	flag.BoolVar(&f.ExcludeSameEntry, "exclude-same-entry",
+		f.ExcludeSameEntry,
+		"When set, results from the same PDB entry as the query are not "+
+			"shown.")
+	flag.StringVar(&f.Meta, "meta", f.Meta,
+		"When set, only results whose meta data has these values are "+
+			"shown. Values are given as comma separated 'key=value' pairs, "+
+			"e.g., 'scop=a.1.1.1,chain=A'.")
+	return f
+}
+
+// DB opens the BOW database at the path given, which is memory mapped if
+// the '-mmap' flag is set.
+func (f *SearchFlags) DB(fpath string) *bowdb.DB {
+	open := bowdb.Open
+	if f.Mmap {
+		open = bowdb.OpenMapped
+	}
+	db, err := open(fpath)
+	Assert(err, "Could not open BOW database '%s'", fpath)
+	return db
+}
+
+// Options returns search options corresponding to the flags. If the flags
+// are invalid, the program quits.
+func (f *SearchFlags) Options() bowdb.SearchOptions {
+	opts := bowdb.SearchOptions{
+		Limit: f.Limit,
+		Min:   f.Min,
+		Max:   f.Max,
+		Order: bowdb.OrderAsc,
+	}
+	switch f.Sort {
+	case "cosine":
+		opts.SortBy = bowdb.SortByCosine
+	case "euclid":
+		opts.SortBy = bowdb.SortByEuclid
+	default:
+		log.Fatalf("Unrecognized sort metric '%s'.", f.Sort)
+	}
+	if f.Desc {
+		opts.Order = bowdb.OrderDesc
+	}
+	if len(f.IdPrefix) > 0 {
+		prefixes := strings.Split(f.IdPrefix, ",")
+		opts.Filters = append(opts.Filters, bowdb.IdPrefix(prefixes...))
+	}
+	if len(f.ExcludeIdPrefix) > 0 {
+		prefixes := strings.Split(f.ExcludeIdPrefix, ",")
+		opts.Filters = append(opts.Filters,
+			bowdb.Not(bowdb.IdPrefix(prefixes...)))
+	}
+	if len(f.IdRegexp) > 0 {
+		re, err := regexp.Compile(f.IdRegexp)
+		Assert(err, "Invalid regular expression '%s'", f.IdRegexp)
+		opts.Filters = append(opts.Filters, bowdb.IdRegexp(re))
+	}
+	if len(f.ExcludeIdRegexp) > 0 {
+		re, err := regexp.Compile(f.ExcludeIdRegexp)
+		Assert(err, "Invalid regular expression '%s'", f.ExcludeIdRegexp)
+		opts.Filters = append(opts.Filters, bowdb.Not(bowdb.IdRegexp(re)))
+	}
+	if f.ExcludeQuery {
+		opts.Filters = append(opts.Filters, bowdb.ExcludeQuery)
+	}
+	if f.ExcludeSameEntry {
+		opts.Filters = append(opts.Filters, bowdb.ExcludeSameEntry)
+	}
+	if len(f.Meta) > 0 {
+		for _, pair := range strings.Split(f.Meta, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				log.Fatalf("Invalid meta data value '%s'.", pair)
+			}
+			opts.Filters = append(opts.Filters, bowdb.MetaEquals(kv[0], kv[1]))
+		}
+	}
+	return opts
+}
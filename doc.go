@@ -18,6 +18,11 @@ functionality can be added to existing libraries by wrapping them with
 additional information. (For example, see the implementation of the
 WeightedTfIdf library.)
 
+A structure library and a sequence library with the same fragment numbering
+may be combined into a single paired library (see NewPaired). Paired libraries
+satisfy both the StructureLibrary and SequenceLibrary interfaces, so that BOWs
+computed from structures and BOWs computed from sequences can be compared.
+
 A central design decision of this package is that all fragment libraries are
 immutable. Once they are created, they cannot be changed. Therefore, all
 actions defined by the Library interfaces never mutate an existing library.
@@ -0,0 +1,89 @@
+package fragbag
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// fingerprintCache is embedded in the libraries defined in this package to
+// store their fingerprint once it has been computed. This is safe since
+// libraries are immutable.
+type fingerprintCache struct {
+	lock sync.Mutex
+	fp   string
+}
+
+// fingerprints returns the cache of a library.
+func (c *fingerprintCache) fingerprints() *fingerprintCache {
+	return c
+}
+
+// cachedLibrary is implemented by libraries with a fingerprint cache.
+type cachedLibrary interface {
+	fingerprints() *fingerprintCache
+}
+
+// Fingerprint returns a stable fingerprint of the contents of a fragment
+// library. Two libraries have the same fingerprint if and only if they have
+// the same tags, the same name and the same fragments. Fingerprints are
+// useful for checking whether two values (like a BOW and a BOW database)
+// were computed with the same fragment library.
+//
+// Since the name is part of the fingerprint, renaming a library (or any of
+// its sub-libraries) changes its fingerprint. BOWs computed with a renamed
+// library are therefore rejected by BOW databases made with the original
+// one, even if the fragments are identical.
+//
+// The fingerprint is a hex encoded SHA-256 hash of the binary encoding of the
+// library (see SaveBinary). The fingerprints of libraries defined in this
+// package are cached, so that computing the fingerprint of the same library
+// more than once is cheap.
+//
+// If the library cannot be encoded, an empty string is returned. An empty
+// fingerprint should be interpreted as unknown.
+func Fingerprint(lib Library) string {
+	clib, ok := lib.(cachedLibrary)
+	if !ok {
+		return computeFingerprint(lib)
+	}
+
+	cache := clib.fingerprints()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if len(cache.fp) == 0 {
+		cache.fp = computeFingerprint(lib)
+	}
+	return cache.fp
+}
+
+// computeFingerprint computes the fingerprint of a library without using its
+// cache.
+func computeFingerprint(lib Library) string {
+	h := sha256.New()
+	enc := &binEncoder{w: bufio.NewWriter(h)}
+	enc.library(lib)
+	if enc.err == nil {
+		enc.err = enc.w.Flush()
+	}
+	if enc.err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkFingerprint computes the fingerprint of a library that was just read
+// and returns an error if it differs from the fingerprint stored with the
+// library. An empty stored fingerprint is not checked.
+func checkFingerprint(lib Library, stored string) error {
+	if len(stored) == 0 {
+		return nil
+	}
+	if fp := Fingerprint(lib); fp != stored {
+		return fmt.Errorf("Corrupt fragment library. Its fingerprint is "+
+			"'%s' but '%s' is stored with it.", fp, stored)
+	}
+	return nil
+}
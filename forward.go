@@ -0,0 +1,108 @@
+package fragbag
+
+import (
+	"math"
+
+	"github.com/TuftsBCB/seq"
+)
+
+// forwardTable is reusable memory for computing the forward probability of
+// a sequence against a profile HMM. Only one goroutine can use a table at a
+// time.
+//
+// All values are stored as negative log probabilities, so that a value of
+// seq.MinProb corresponds to a probability of zero.
+type forwardTable struct {
+	mat, ins, del [][]seq.Prob
+}
+
+// allocForwardTable allocates memory suitable for computing the forward
+// probability of sequences with length seqLen against HMMs with the given
+// number of nodes.
+func allocForwardTable(nodes, seqLen int) *forwardTable {
+	alloc := func() [][]seq.Prob {
+		rows := make([][]seq.Prob, nodes)
+		for i := range rows {
+			rows[i] = make([]seq.Prob, seqLen+1)
+		}
+		return rows
+	}
+	return &forwardTable{alloc(), alloc(), alloc()}
+}
+
+// forward computes the probability of the sequence given summed over all
+// paths through the HMM given. The sequence must be emitted in its entirety
+// starting at the match state of the first node and finishing in any state of
+// the last node.
+//
+// The table must have been allocated with at least as many nodes as the HMM
+// and with exactly the length of the sequence.
+func (t *forwardTable) forward(hmm *seq.HMM, s seq.Sequence) seq.Prob {
+	nodes, slen := len(hmm.Nodes), s.Len()
+	mat, ins, del := t.mat, t.ins, t.del
+
+	// Row i of each table corresponds to the number of residues emitted so
+	// far, where the most recent residue is s.Residues[i-1].
+	for j := 0; j < nodes; j++ {
+		for i := 0; i <= slen; i++ {
+			mat[j][i] = seq.MinProb
+			ins[j][i] = seq.MinProb
+			del[j][i] = seq.MinProb
+		}
+	}
+	if nodes == 0 || slen == 0 {
+		return seq.MinProb
+	}
+	first := hmm.Nodes[0]
+	mat[0][1] = first.MatEmit.Lookup(s.Residues[0])
+	for i := 2; i <= slen; i++ {
+		ins[0][i] = first.InsEmit.Lookup(s.Residues[i-1]) + probSum(
+			mat[0][i-1]+first.Transitions.MI,
+			ins[0][i-1]+first.Transitions.II)
+	}
+	for j := 1; j < nodes; j++ {
+		prev, node := hmm.Nodes[j-1].Transitions, hmm.Nodes[j]
+		for i := 0; i <= slen; i++ {
+			del[j][i] = probSum(mat[j-1][i]+prev.MD, del[j-1][i]+prev.DD)
+			if i == 0 {
+				continue
+			}
+
+			r := s.Residues[i-1]
+			mat[j][i] = node.MatEmit.Lookup(r) + probSum(
+				probSum(mat[j-1][i-1]+prev.MM, ins[j-1][i-1]+prev.IM),
+				del[j-1][i-1]+prev.DM)
+			ins[j][i] = node.InsEmit.Lookup(r) + probSum(
+				mat[j][i-1]+node.Transitions.MI,
+				ins[j][i-1]+node.Transitions.II)
+		}
+	}
+	last := nodes - 1
+	return probSum(probSum(mat[last][slen], ins[last][slen]), del[last][slen])
+}
+
+// nullScore returns the probability of the sequence given under a null model
+// where each residue is emitted independently.
+func nullScore(null seq.EProbs, s seq.Sequence) seq.Prob {
+	prob := seq.Prob(0.0)
+	for _, r := range s.Residues {
+		prob += null.Lookup(r)
+	}
+	return prob
+}
+
+// probSum adds two probabilities represented as negative log probabilities.
+// The computation is done without leaving log space for numeric stability.
+func probSum(p1, p2 seq.Prob) seq.Prob {
+	switch {
+	case math.IsInf(float64(p1), 1):
+		return p2
+	case math.IsInf(float64(p2), 1):
+		return p1
+	}
+	lo, hi := float64(p1), float64(p2)
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+	return seq.Prob(lo - math.Log1p(math.Exp(lo-hi)))
+}
@@ -8,7 +8,8 @@ import (
 // Library defines the base methods necessary for any value to be considered
 // a fragment library. All libraries that do *not* wrap another library should
 // implement either the Structure or Sequence library interfaces and never
-// both.
+// both. The only exception are paired libraries (see PairedLibrary), which
+// implement both.
 type Library interface {
 	// Name returns a canonical name for this fragment library.
 	Name() string
@@ -28,7 +29,7 @@ type Library interface {
 	// nil otherwise. When non-nil, this library is a wrapper library which
 	// may implement both the StructureLibrary and SequenceLibrary interfaces.
 	// When nil, it is guaranteed that only one of the interfaces will be
-	// satisfied.
+	// satisfied, unless the library is a PairedLibrary.
 	SubLibrary() Library
 
 	// String returns a custom string representation of the library.
@@ -80,6 +81,25 @@ type SequenceLibrary interface {
 	AlignmentProb(fragNum int, query seq.Sequence) seq.Prob
 }
 
+// ProfileLibrary adds methods for comparing the fragments of a sequence
+// library with query profiles, like the profile of a multiple sequence
+// alignment. (See the BestProfileFragment function for libraries that don't
+// implement this interface.)
+type ProfileLibrary interface {
+	SequenceLibrary
+
+	// BestProfileFragment returns the fragment number of the best matching
+	// fragment against the profile given. Note that the profile given must
+	// have N columns where N is the size of each fragment in this library.
+	//
+	// If no "good" fragments can be found, then `-1` is returned.
+	BestProfileFragment(*seq.Profile) int
+
+	// ProfileProb returns the probability (as a negative log-odds) that a
+	// query profile matches a particular fragment.
+	ProfileProb(fragNum int, query *seq.Profile) seq.Prob
+}
+
 // WeightedLibrary adds methods specific to the operations defined on a
 // library of weighted fragments.
 type WeightedLibrary interface {
@@ -91,3 +111,25 @@ type WeightedLibrary interface {
 	// query.)
 	AddWeights(fragNum int, frequency float32) float32
 }
+
+// PairedLibrary describes a library made up of a structure library and a
+// sequence library with the same fragment numbering. Namely, fragment i in
+// the structure library corresponds to fragment i in the sequence library.
+// This means that BOWs computed from structures and BOWs computed from
+// sequences live in the same vector space.
+type PairedLibrary interface {
+	StructureLibrary
+
+	// BestSequenceFragment calls the corresponding method on the sequence
+	// library.
+	BestSequenceFragment(seq.Sequence) int
+
+	// AlignmentProb calls the corresponding method on the sequence library.
+	AlignmentProb(fragNum int, query seq.Sequence) seq.Prob
+
+	// StructureLibrary returns the structure half of the paired library.
+	StructureLibrary() StructureLibrary
+
+	// SequenceLibrary returns the sequence half of the paired library.
+	SequenceLibrary() SequenceLibrary
+}
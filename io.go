@@ -1,6 +1,7 @@
 package fragbag
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -13,6 +14,7 @@ const (
 	libTagSequenceProfile = "sequence-profile"
 	libTagSequenceHMM     = "sequence-hmm"
 	libTagWeightedTfIdf   = "weighted-tfidf"
+	libTagPaired          = "paired"
 )
 
 // MakeEmptyLib represents a function that returns an empty value whose type
@@ -47,27 +49,30 @@ func init() {
 		return &sequenceHMM{}, nil
 	}
 	Openers[libTagWeightedTfIdf] = makeWeightedTfIdf
+	Openers[libTagPaired] = func(...string) (Library, error) {
+		return &paired{}, nil
+	}
 }
 
-// Open reads a library from the reader provided. If there is a problem
-// reading or parsing the data as a library, an error is returned.
-// If no error is returned, the Library returned is guarnateed to satisfy
-// either the StructureLibrary or SequenceLibrary interfaces.
-// It is possible that a wrapper library is returned which satisfy both the
-// StructureLibrary and SequenceLibrary interfaces. This type of library can
-// be inspected with the SubLibrary interface method, along with the IsStructure
-// and IsSequence functions in this module.
-func Open(r io.Reader) (Library, error) {
-	type jsonLibrary struct {
-		Tags    []string
-		Library json.RawMessage
-	}
+// jsonLibrary is the on-disk representation of a fragment library. The tags
+// are used to recover the type of the library before decoding it.
+type jsonLibrary struct {
+	Tags        []string
+	Fingerprint string `json:",omitempty"`
+	Library     json.RawMessage
+}
 
-	var jsonlib jsonLibrary
-	dec := json.NewDecoder(r)
-	if err := dec.Decode(&jsonlib); err != nil {
-		return nil, err
+// newJsonLibrary encodes the library given along with its full tag.
+func newJsonLibrary(lib Library) (jsonLibrary, error) {
+	raw, err := json.Marshal(lib)
+	if err != nil {
+		return jsonLibrary{}, err
 	}
+	return jsonLibrary{Tags: fullTag(lib), Library: raw}, nil
+}
+
+// open decodes the library into a value with a type determined by its tags.
+func (jsonlib jsonLibrary) open() (Library, error) {
 	if len(jsonlib.Tags) == 0 {
 		return nil, fmt.Errorf("Corrupt fragment library. No tags founds.")
 	}
@@ -77,13 +82,44 @@ func Open(r io.Reader) (Library, error) {
 		return nil, err
 	}
 
-	dec = json.NewDecoder(bytes.NewReader(jsonlib.Library))
+	dec := json.NewDecoder(bytes.NewReader(jsonlib.Library))
 	if err := dec.Decode(&empty); err != nil {
 		return nil, err
 	}
+	if err := checkFingerprint(empty, jsonlib.Fingerprint); err != nil {
+		return nil, err
+	}
 	return empty, nil
 }
 
+// Open reads a library from the reader provided. If there is a problem
+// reading or parsing the data as a library, an error is returned.
+// The library may be encoded as JSON (see Save) or in binary (see
+// SaveBinary). The encoding is detected automatically.
+// If no error is returned, the Library returned is guarnateed to satisfy
+// either the StructureLibrary or SequenceLibrary interfaces.
+// It is possible that a wrapper library is returned which satisfy both the
+// StructureLibrary and SequenceLibrary interfaces. This type of library can
+// be inspected with the SubLibrary interface method, along with the IsStructure
+// and IsSequence functions in this module.
+//
+// An error is returned if the library was saved with a fingerprint that
+// differs from the fingerprint of the library read. (See Save.)
+func Open(r io.Reader) (Library, error) {
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(len(binaryMagic))
+	if err == nil && string(magic) == binaryMagic {
+		return openBinary(buf)
+	}
+
+	var jsonlib jsonLibrary
+	dec := json.NewDecoder(buf)
+	if err := dec.Decode(&jsonlib); err != nil {
+		return nil, err
+	}
+	return jsonlib.open()
+}
+
 // makeEmptySubLibrary recursively dispatches the opener functions so that
 // libraries with sub-libraries are reconstructed with the right types.
 func makeEmptySubLibrary(subTags ...string) (Library, error) {
@@ -105,11 +141,19 @@ func makeEmptySubLibrary(subTags ...string) (Library, error) {
 	return empty, nil
 }
 
-// Save stores the given fragment library with the writer provided.
+// Save stores the given fragment library with the writer provided. The
+// library is encoded as JSON. Use SaveBinary for a more compact encoding that
+// is faster to read.
+//
+// The fingerprint of the library (see Fingerprint) is saved along with the
+// library. When the library is opened, its fingerprint is computed from its
+// fragments and checked against the saved fingerprint, so that a library that
+// was edited or corrupted after it was saved is detected.
 func Save(w io.Writer, lib Library) error {
 	return niceJson(w, map[string]interface{}{
-		"Tags":    fullTag(lib),
-		"Library": lib,
+		"Tags":        fullTag(lib),
+		"Fingerprint": Fingerprint(lib),
+		"Library":     lib,
 	})
 }
 
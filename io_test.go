@@ -0,0 +1,127 @@
+package fragbag
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/TuftsBCB/structure"
+)
+
+func testLibrary(t *testing.T) WeightedLibrary {
+	frags := [][]structure.Coords{
+		{{1, 2, 3}, {4.5, -5.25, 6}, {-7.125, 8, 9.5}},
+		{{0, 0, 0}, {3.8, 0, 0}, {7.6, 1.2, -0.5}},
+	}
+	lib, err := NewStructureAtoms("test", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wlib, err := NewWeightedTfIdf(lib, []float32{0.5, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wlib
+}
+
+func TestSaveOpen(t *testing.T) {
+	savers := map[string]func(io.Writer, Library) error{
+		"json":   Save,
+		"binary": SaveBinary,
+	}
+	for name, save := range savers {
+		lib := testLibrary(t)
+
+		buf := new(bytes.Buffer)
+		if err := save(buf, lib); err != nil {
+			t.Fatalf("Could not save %s library: %s", name, err)
+		}
+		read, err := Open(buf)
+		if err != nil {
+			t.Fatalf("Could not open %s library: %s", name, err)
+		}
+
+		wread, ok := read.(WeightedLibrary)
+		if !ok {
+			t.Fatalf("The %s library opened is not weighted.", name)
+		}
+		if read.Name() != lib.Name() {
+			t.Fatalf("Expected %s library with name '%s' but got '%s'.",
+				name, lib.Name(), read.Name())
+		}
+		for i := 0; i < lib.Size(); i++ {
+			if lib.AddWeights(i, 1) != wread.AddWeights(i, 1) {
+				t.Fatalf("Weight %d of %s library: expected %f but got %f.",
+					i, name, lib.AddWeights(i, 1), wread.AddWeights(i, 1))
+			}
+		}
+		assertSameAtoms(t, lib.(StructureLibrary), read.(StructureLibrary))
+	}
+}
+
+func TestOpenFingerprint(t *testing.T) {
+	lib := testLibrary(t)
+	buf := new(bytes.Buffer)
+	if err := Save(buf, lib); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.String()
+
+	read, err := Open(strings.NewReader(saved))
+	if err != nil {
+		t.Fatalf("Could not open library: %s", err)
+	}
+	if Fingerprint(read) != Fingerprint(lib) {
+		t.Fatalf("Expected fingerprint '%s' but got '%s'.",
+			Fingerprint(lib), Fingerprint(read))
+	}
+
+	edited := strings.Replace(saved, "4.5", "4.75", 1)
+	if edited == saved {
+		t.Fatal("Could not edit the saved library.")
+	}
+	if _, err := Open(strings.NewReader(edited)); err == nil {
+		t.Fatal("Expected an error opening an edited library.")
+	}
+}
+
+func TestFingerprintName(t *testing.T) {
+	frags := [][]structure.Coords{{{0, 0, 0}, {3.8, 0, 0}, {7.6, 1.2, -0.5}}}
+	lib1, err := NewStructureAtoms("test", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib2, err := NewStructureAtoms("renamed", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(lib1) == Fingerprint(lib2) {
+		t.Fatal("Expected libraries with different names to have different " +
+			"fingerprints.")
+	}
+}
+
+func TestOpenBinaryCorrupt(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := SaveBinary(buf, testLibrary(t)); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	for n := len(binaryMagic); n < len(saved); n++ {
+		if _, err := Open(bytes.NewReader(saved[:n])); err == nil {
+			t.Fatalf("Expected an error opening a library truncated to "+
+				"%d of %d bytes.", n, len(saved))
+		}
+	}
+
+	// The length of the fingerprint follows the magic bytes and the
+	// version. A huge length must be rejected before it is allocated.
+	huge := append([]byte{}, saved...)
+	off := len(binaryMagic) + 2
+	copy(huge[off:off+4], []byte{0xff, 0xff, 0xff, 0xf0})
+	if _, err := Open(bytes.NewReader(huge)); err == nil {
+		t.Fatal("Expected an error opening a library with a huge length.")
+	}
+}
@@ -0,0 +1,228 @@
+package fragbag
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+)
+
+var (
+	_ = PairedLibrary(&paired{})
+	_ = ProfileLibrary(&paired{})
+)
+
+// paired represents a structure fragment library and a sequence fragment
+// library that share the same fragment numbering.
+//
+// Since a paired library contains two libraries, it cannot be described by
+// a single chain of tags. Instead, the tags of each library are stored
+// along with the library itself.
+type paired struct {
+	fingerprintCache
+	ident     string
+	structure StructureLibrary
+	sequence  SequenceLibrary
+}
+
+// PairedFragment is the representation of a fragment in a paired library.
+// It contains the representations of the corresponding fragments in the
+// structure and sequence libraries.
+type PairedFragment struct {
+	Structure interface{}
+	Sequence  interface{}
+}
+
+// NewPaired pairs a structure library with a sequence library. Both
+// libraries must have the same number of fragments and the same fragment
+// size. It is the caller's responsibility to ensure that the fragments of
+// each library are numbered the same way. (For example, sequence libraries
+// built with a SequenceBuilder are numbered according to the structure
+// library used to build them.)
+//
+// Neither library may be weighted, since a paired library would lose its
+// weights. Instead, a paired library may itself be wrapped by a weighted
+// library (e.g., with NewWeightedTfIdf).
+func NewPaired(
+	name string,
+	structLib StructureLibrary,
+	seqLib SequenceLibrary,
+) (PairedLibrary, error) {
+	if !IsStructure(structLib) {
+		return nil, fmt.Errorf("Library '%s' is not a structure library.",
+			structLib.Name())
+	}
+	if !IsSequence(seqLib) {
+		return nil, fmt.Errorf("Library '%s' is not a sequence library.",
+			seqLib.Name())
+	}
+	if err := checkUnweighted(name, structLib, seqLib); err != nil {
+		return nil, err
+	}
+	if structLib.Size() != seqLib.Size() {
+		return nil, fmt.Errorf("Cannot pair libraries with a different number "+
+			"of fragments. Structure library '%s' has %d fragments while "+
+			"sequence library '%s' has %d fragments.",
+			structLib.Name(), structLib.Size(), seqLib.Name(), seqLib.Size())
+	}
+	if structLib.FragmentSize() != seqLib.FragmentSize() {
+		return nil, fmt.Errorf("Cannot pair libraries with different fragment "+
+			"sizes. Structure library '%s' has fragment size %d while "+
+			"sequence library '%s' has fragment size %d.",
+			structLib.Name(), structLib.FragmentSize(),
+			seqLib.Name(), seqLib.FragmentSize())
+	}
+	return &paired{ident: name, structure: structLib, sequence: seqLib}, nil
+}
+
+// checkUnweighted returns an error if any of the libraries given, which are
+// the halves of the paired library named, is weighted.
+func checkUnweighted(name string, libs ...Library) error {
+	for _, lib := range libs {
+		if _, ok := lib.(WeightedLibrary); ok {
+			return fmt.Errorf("Library '%s' in paired library '%s' is "+
+				"weighted. Wrap the paired library with weights instead.",
+				lib.Name(), name)
+		}
+	}
+	return nil
+}
+
+// SubLibrary returns nil, since a paired library does not wrap a single
+// library. Use the StructureLibrary and SequenceLibrary methods instead.
+func (lib *paired) SubLibrary() Library {
+	return nil
+}
+
+func (lib *paired) StructureLibrary() StructureLibrary {
+	return lib.structure
+}
+
+func (lib *paired) SequenceLibrary() SequenceLibrary {
+	return lib.sequence
+}
+
+func (lib *paired) Tag() string {
+	return libTagPaired
+}
+
+// Size returns the number of fragments in the library.
+func (lib *paired) Size() int {
+	return lib.structure.Size()
+}
+
+// FragmentSize returns the size of every fragment in the library.
+func (lib *paired) FragmentSize() int {
+	return lib.structure.FragmentSize()
+}
+
+// String returns a string with the name of the library, the number of
+// fragments in the library, the size of each fragment and the names of the
+// structure and sequence libraries.
+func (lib *paired) String() string {
+	return fmt.Sprintf("%s (%d, %d, structure: %s, sequence: %s)",
+		lib.ident, lib.Size(), lib.FragmentSize(),
+		lib.structure.Name(), lib.sequence.Name())
+}
+
+func (lib *paired) Name() string {
+	return lib.ident
+}
+
+// Fragment returns a PairedFragment value.
+func (lib *paired) Fragment(fragNum int) interface{} {
+	return PairedFragment{
+		Structure: lib.structure.Fragment(fragNum),
+		Sequence:  lib.sequence.Fragment(fragNum),
+	}
+}
+
+// FragmentString returns the string representations of the corresponding
+// fragments in the structure and sequence libraries.
+func (lib *paired) FragmentString(fragNum int) string {
+	return fmt.Sprintf("%s\n%s",
+		lib.structure.FragmentString(fragNum),
+		lib.sequence.FragmentString(fragNum))
+}
+
+// BestStructureFragment calls the corresponding method on the structure
+// library.
+func (lib *paired) BestStructureFragment(atoms []structure.Coords) int {
+	return lib.structure.BestStructureFragment(atoms)
+}
+
+// Atoms calls the corresponding method on the structure library.
+func (lib *paired) Atoms(fragNum int) []structure.Coords {
+	return lib.structure.Atoms(fragNum)
+}
+
+// BestSequenceFragment calls the corresponding method on the sequence
+// library.
+func (lib *paired) BestSequenceFragment(s seq.Sequence) int {
+	return lib.sequence.BestSequenceFragment(s)
+}
+
+// AlignmentProb calls the corresponding method on the sequence library.
+func (lib *paired) AlignmentProb(fragNum int, s seq.Sequence) seq.Prob {
+	return lib.sequence.AlignmentProb(fragNum, s)
+}
+
+// BestProfileFragment calls the corresponding function on the sequence
+// library. (See the BestProfileFragment function.)
+func (lib *paired) BestProfileFragment(p *seq.Profile) int {
+	return BestProfileFragment(lib.sequence, p)
+}
+
+// ProfileProb calls the corresponding function on the sequence library.
+// (See the ProfileProb function.)
+func (lib *paired) ProfileProb(fragNum int, p *seq.Profile) seq.Prob {
+	return ProfileProb(lib.sequence, fragNum, p)
+}
+
+// jsonPaired is the on-disk representation of a paired library.
+type jsonPaired struct {
+	Ident     string
+	Structure jsonLibrary
+	Sequence  jsonLibrary
+}
+
+func (lib *paired) MarshalJSON() ([]byte, error) {
+	structLib, err := newJsonLibrary(lib.structure)
+	if err != nil {
+		return nil, err
+	}
+	seqLib, err := newJsonLibrary(lib.sequence)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPaired{lib.ident, structLib, seqLib})
+}
+
+func (lib *paired) UnmarshalJSON(data []byte) error {
+	var jsonlib jsonPaired
+	if err := json.Unmarshal(data, &jsonlib); err != nil {
+		return err
+	}
+
+	structLib, err := jsonlib.Structure.open()
+	if err != nil {
+		return err
+	}
+	seqLib, err := jsonlib.Sequence.open()
+	if err != nil {
+		return err
+	}
+
+	var ok bool
+	lib.ident = jsonlib.Ident
+	if lib.structure, ok = structLib.(StructureLibrary); !ok {
+		return fmt.Errorf("Library '%s' in paired library '%s' is not a "+
+			"structure library.", structLib.Name(), lib.ident)
+	}
+	if lib.sequence, ok = seqLib.(SequenceLibrary); !ok {
+		return fmt.Errorf("Library '%s' in paired library '%s' is not a "+
+			"sequence library.", seqLib.Name(), lib.ident)
+	}
+	return checkUnweighted(lib.ident, structLib, seqLib)
+}
@@ -0,0 +1,36 @@
+package fragbag
+
+import (
+	"testing"
+
+	"github.com/TuftsBCB/seq"
+)
+
+func TestPairedWeighted(t *testing.T) {
+	wlib := testLibrary(t)
+	alpha := seq.Alphabet("AB")
+	frags := make([]*seq.Profile, wlib.Size())
+	for i := range frags {
+		frags[i] = seq.NewProfileAlphabet(wlib.FragmentSize(), alpha)
+		for c := range frags[i].Emissions {
+			frags[i].Emissions[c] = testEProbs(alpha, 0.5, 0.5)
+		}
+	}
+	seqLib, err := NewSequenceProfile("test", frags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	structLib := wlib.(StructureLibrary)
+	if _, err := NewPaired("test", structLib, seqLib); err == nil {
+		t.Fatal("Expected an error pairing a weighted structure library.")
+	}
+	structLib = wlib.SubLibrary().(StructureLibrary)
+	lib, err := NewPaired("test", structLib, seqLib)
+	if err != nil {
+		t.Fatalf("Could not pair unweighted libraries: %s", err)
+	}
+	if _, ok := lib.(WeightedLibrary); ok {
+		t.Fatal("A paired library of unweighted libraries is weighted.")
+	}
+}
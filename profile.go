@@ -0,0 +1,123 @@
+package fragbag
+
+import (
+	"fmt"
+
+	"github.com/TuftsBCB/seq"
+)
+
+// BestProfileFragment returns the fragment number of the best matching
+// fragment in the library given against the profile given. If the library
+// implements ProfileLibrary, then its BestProfileFragment method is used.
+// Otherwise, the consensus sequence of the profile (the most probable
+// residue of each column) is used as the query.
+func BestProfileFragment(lib SequenceLibrary, p *seq.Profile) int {
+	if plib, ok := lib.(ProfileLibrary); ok {
+		return plib.BestProfileFragment(p)
+	}
+	return lib.BestSequenceFragment(consensus(p))
+}
+
+// ProfileProb returns the probability that a query profile matches a
+// particular fragment of the library given. It falls back to the consensus
+// sequence of the profile just like BestProfileFragment.
+func ProfileProb(lib SequenceLibrary, fragNum int, p *seq.Profile) seq.Prob {
+	if plib, ok := lib.(ProfileLibrary); ok {
+		return plib.ProfileProb(fragNum, p)
+	}
+	return lib.AlignmentProb(fragNum, consensus(p))
+}
+
+// consensus returns the most probable residue of every column of the
+// profile given as a sequence.
+func consensus(p *seq.Profile) seq.Sequence {
+	residues := make([]seq.Residue, p.Len())
+	for c, column := range p.Emissions {
+		best := seq.MinProb
+		for _, r := range p.Alphabet {
+			if prob := column.Lookup(r); best.Less(prob) {
+				best, residues[c] = prob, r
+			}
+		}
+	}
+	return seq.Sequence{Name: p.Name, Residues: residues}
+}
+
+// columnProb returns the probability (as a negative log) that a column of a
+// query profile is emitted by a column of a fragment. Namely, it is the sum
+// over residues of the query probability of each residue multiplied by its
+// fragment probability. If bg is not nil, then each fragment probability is
+// divided by the background probability of its residue, which makes the
+// result a log-odds score.
+//
+// Only the residues given are considered, which should be the residues that
+// are in the alphabets of both the query and the fragment.
+func columnProb(
+	query, frag seq.EProbs,
+	residues []seq.Residue,
+	bg map[seq.Residue]float64,
+) seq.Prob {
+	sum := 0.0
+	for _, r := range residues {
+		p := query.Lookup(r).Ratio() * frag.Lookup(r).Ratio()
+		if bg != nil {
+			if bg[r] <= 0 {
+				continue
+			}
+			p /= bg[r]
+		}
+		sum += p
+	}
+	return negLog(sum)
+}
+
+// commonResidues returns the residues that are in both alphabets given.
+func commonResidues(a1, a2 seq.Alphabet) []seq.Residue {
+	var common []seq.Residue
+	for _, r1 := range a1 {
+		for _, r2 := range a2 {
+			if r1 == r2 {
+				common = append(common, r1)
+				break
+			}
+		}
+	}
+	return common
+}
+
+// nullRatios returns the probabilities of the residues in the alphabet of the
+// distribution given. When queryComp is greater than zero, the probabilities
+// are corrected for the average composition of the query profile given, just
+// like the background of a sequence profile library.
+func nullRatios(
+	null seq.EProbs,
+	queryComp float64,
+	p *seq.Profile,
+) map[seq.Residue]float64 {
+	ratios := make(map[seq.Residue]float64, len(null.Alphabet))
+	for _, r := range null.Alphabet {
+		ratios[r] = null.Lookup(r).Ratio()
+	}
+	if queryComp == 0 || p.Len() == 0 {
+		return ratios
+	}
+
+	n := float64(p.Len())
+	for _, r := range commonResidues(null.Alphabet, p.Alphabet) {
+		comp := 0.0
+		for _, column := range p.Emissions {
+			comp += column.Lookup(r).Ratio()
+		}
+		ratios[r] = (1-queryComp)*ratios[r] + queryComp*comp/n
+	}
+	return ratios
+}
+
+// checkProfile panics if the profile given does not have the number of
+// columns given.
+func checkProfile(p *seq.Profile, columns int) {
+	if p.Len() != columns {
+		panic(fmt.Sprintf("Profile length %d != fragment size %d",
+			p.Len(), columns))
+	}
+}
@@ -0,0 +1,62 @@
+package fragbag
+
+import (
+	"testing"
+
+	"github.com/TuftsBCB/seq"
+)
+
+// sequenceOnly hides the ProfileLibrary methods of a library, so that
+// profiles are compared with it by their consensus sequences.
+type sequenceOnly struct {
+	SequenceLibrary
+}
+
+func TestProfileConsensus(t *testing.T) {
+	// The second fragment emits the reverse of the first.
+	hmm1, hmm2 := testHMM(), testHMM()
+	nodes := make([]seq.HMMNode, len(hmm1.Nodes))
+	copy(nodes, hmm1.Nodes)
+	for i := range nodes {
+		m := hmm1.Nodes[i].MatEmit
+		nodes[i].MatEmit = testEProbs(m.Alphabet,
+			m.Lookup('B').Ratio(), m.Lookup('A').Ratio())
+	}
+	hmm2.Nodes = nodes
+	hlib, err := NewSequenceHMMScoring("test", []*seq.HMM{hmm1, hmm2},
+		HMMForward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := sequenceOnly{hlib}
+
+	alpha := seq.Alphabet("AB")
+	tests := []struct {
+		probA     []float64 // of every column
+		consensus string
+	}{
+		{[]float64{0.7, 0.2, 0.4}, "ABB"},
+		{[]float64{0.1, 0.9, 0.6}, "BAA"},
+		{[]float64{1, 0, 1}, "ABA"},
+	}
+	for _, test := range tests {
+		p := seq.NewProfileAlphabet(len(test.probA), alpha)
+		for c, pa := range test.probA {
+			p.Emissions[c] = testEProbs(alpha, pa, 1-pa)
+		}
+		s := seq.NewSequenceString("consensus", test.consensus)
+
+		expected := hlib.BestSequenceFragment(s)
+		if got := BestProfileFragment(lib, p); got != expected {
+			t.Fatalf("%s: Expected fragment %d but got %d.",
+				test.consensus, expected, got)
+		}
+		for i := 0; i < hlib.Size(); i++ {
+			expected := hlib.AlignmentProb(i, s)
+			if got := ProfileProb(lib, i, p); got != expected {
+				t.Fatalf("%s: Expected probability %f for fragment %d but "+
+					"got %f.", test.consensus, expected, i, got)
+			}
+		}
+	}
+}
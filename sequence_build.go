@@ -0,0 +1,248 @@
+package fragbag
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+)
+
+// SequenceBuilder accumulates residue statistics for each fragment in a
+// structure library. Once training data has been added, a sequence fragment
+// library can be produced whose fragment numbering is identical to the
+// numbering of the structure library.
+//
+// Training works by assigning every window of alpha-carbon atoms in a
+// training structure to its best structure fragment, and adding the residues
+// of the corresponding sequence window to the statistics of that fragment.
+//
+// It is safe to call Add and AddChain from multiple goroutines.
+type SequenceBuilder struct {
+	lib      StructureLibrary
+	alphabet seq.Alphabet
+
+	lock    *sync.Mutex
+	windows []int                   // Number of windows per fragment.
+	counts  [][]map[seq.Residue]int // Residue counts per fragment column.
+}
+
+// NewSequenceBuilder returns a builder for sequence fragment libraries that
+// correspond to the structure library given. Only residues in the alphabet
+// given are counted.
+func NewSequenceBuilder(
+	lib StructureLibrary,
+	alphabet seq.Alphabet,
+) *SequenceBuilder {
+	b := &SequenceBuilder{
+		lib:      lib,
+		alphabet: alphabet,
+		lock:     new(sync.Mutex),
+		windows:  make([]int, lib.Size()),
+		counts:   make([][]map[seq.Residue]int, lib.Size()),
+	}
+	for i := range b.counts {
+		b.counts[i] = make([]map[seq.Residue]int, lib.FragmentSize())
+		for c := range b.counts[i] {
+			b.counts[i][c] = make(map[seq.Residue]int, len(alphabet))
+		}
+	}
+	return b
+}
+
+// Add assigns each window of the atoms given to a structure fragment and adds
+// the corresponding window of the sequence to that fragment's statistics.
+// The sequence and the atoms must be aligned. Namely, the residue at index i
+// must correspond to the alpha-carbon atom at index i.
+func (b *SequenceBuilder) Add(s seq.Sequence, atoms []structure.Coords) error {
+	if s.Len() != len(atoms) {
+		return fmt.Errorf("Sequence '%s' has length %d but %d alpha-carbon "+
+			"atoms were given.", s.Name, s.Len(), len(atoms))
+	}
+
+	fragSize := b.lib.FragmentSize()
+	best := make([]int, 0, len(atoms))
+	for i := 0; i <= len(atoms)-fragSize; i++ {
+		best = append(best, b.lib.BestStructureFragment(atoms[i:i+fragSize]))
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, fragNum := range best {
+		if fragNum < 0 {
+			continue
+		}
+		b.windows[fragNum]++
+		for c := 0; c < fragSize; c++ {
+			b.counts[fragNum][c][s.Residues[i+c]]++
+		}
+	}
+	return nil
+}
+
+// AddChain adds the residues of the first model of a PDB chain to the
+// statistics of the builder. Residues without an alpha-carbon atom are
+// skipped, and the chain is split into separate pieces wherever residues are
+// missing so that windows never span a gap.
+func (b *SequenceBuilder) AddChain(chain *pdb.Chain) error {
+	if len(chain.Models) == 0 {
+		return nil
+	}
+
+	var residues []seq.Residue
+	var atoms []structure.Coords
+	flush := func() error {
+		s := seq.Sequence{
+			Name:     fmt.Sprintf("%s%c", chain.Entry.IdCode, chain.Ident),
+			Residues: residues,
+		}
+		if err := b.Add(s, atoms); err != nil {
+			return err
+		}
+		residues, atoms = nil, nil
+		return nil
+	}
+
+	lastNum := 0
+	for _, r := range chain.Models[0].Residues {
+		ca, ok := alphaCarbon(r)
+		if !ok {
+			continue
+		}
+		if len(residues) > 0 && r.SequenceNum != lastNum+1 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		residues = append(residues, r.Name)
+		atoms = append(atoms, ca)
+		lastNum = r.SequenceNum
+	}
+	return flush()
+}
+
+// alphaCarbon returns the coordinates of the alpha-carbon atom in the residue
+// given. If the residue has no alpha-carbon, false is returned.
+func alphaCarbon(r *pdb.Residue) (structure.Coords, bool) {
+	for _, atom := range r.Atoms {
+		if atom.Name == "CA" {
+			return atom.Coords, true
+		}
+	}
+	return structure.Coords{}, false
+}
+
+// Profile returns a sequence profile library with one profile for every
+// fragment in the structure library. Emission probabilities are estimated
+// from the residue counts, where `pseudo` pseudocounts are distributed
+// according to the null distribution given. If null is nil, pseudocounts are
+// distributed uniformly over the alphabet. The null distribution is also used
+// as the library's background (see NewSequenceProfileLogOdds).
+func (b *SequenceBuilder) Profile(
+	name string,
+	pseudo float64,
+	null *seq.EProbs,
+) (SequenceLibrary, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	frags := make([]*seq.Profile, b.lib.Size())
+	for i := range frags {
+		frags[i] = seq.NewProfileAlphabet(b.lib.FragmentSize(), b.alphabet)
+		for c := range frags[i].Emissions {
+			frags[i].Emissions[c] = b.emissions(i, c, pseudo, null)
+		}
+	}
+	return NewSequenceProfileLogOdds(name, frags, null, 0)
+}
+
+// HMM returns a sequence HMM library with one profile HMM for every fragment
+// in the structure library. Match emissions are estimated just like the
+// emissions of Profile, while insert emissions are given by the null
+// distribution. If null is nil, a uniform distribution over the alphabet is
+// used as the null distribution. Since training windows never contain gaps,
+// transitions are estimated from the number of windows assigned to each
+// fragment with a single pseudocount for every transition.
+func (b *SequenceBuilder) HMM(
+	name string,
+	pseudo float64,
+	null *seq.EProbs,
+	scoring HMMScoring,
+) (SequenceLibrary, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if null == nil {
+		uniform := seq.NewEProbs(b.alphabet)
+		for _, r := range b.alphabet {
+			uniform.Set(r, negLog(1.0/float64(len(b.alphabet))))
+		}
+		null = &uniform
+	}
+
+	frags := make([]*seq.HMM, b.lib.Size())
+	for i := range frags {
+		n := float64(b.windows[i])
+		trans := seq.TProbs{
+			MM: negLog((n + 1) / (n + 3)),
+			MI: negLog(1 / (n + 3)),
+			MD: negLog(1 / (n + 3)),
+			IM: negLog(0.5),
+			II: negLog(0.5),
+			DM: negLog(0.5),
+			DD: negLog(0.5),
+		}
+
+		nodes := make([]seq.HMMNode, b.lib.FragmentSize())
+		for c := range nodes {
+			nodes[c] = seq.HMMNode{
+				NodeNum:     c,
+				InsEmit:     *null,
+				MatEmit:     b.emissions(i, c, pseudo, null),
+				Transitions: trans,
+			}
+		}
+		frags[i] = seq.NewHMM(nodes, b.alphabet, *null)
+	}
+	return NewSequenceHMMScoring(name, frags, scoring)
+}
+
+// emissions computes the emission probabilities of a single column of a
+// fragment with pseudocounts.
+func (b *SequenceBuilder) emissions(
+	fragNum, column int,
+	pseudo float64,
+	null *seq.EProbs,
+) seq.EProbs {
+	counts := b.counts[fragNum][column]
+	total := 0
+	for _, r := range b.alphabet {
+		total += counts[r]
+	}
+
+	emit := seq.NewEProbs(b.alphabet)
+	uniform := 1.0 / float64(len(b.alphabet))
+	for _, r := range b.alphabet {
+		bg := uniform
+		if null != nil {
+			bg = null.Lookup(r).Ratio()
+		}
+		if total == 0 && pseudo == 0 {
+			emit.Set(r, negLog(bg))
+			continue
+		}
+		p := (float64(counts[r]) + pseudo*bg) / (float64(total) + pseudo)
+		emit.Set(r, negLog(p))
+	}
+	return emit
+}
+
+// negLog converts a probability into a negative log probability.
+func negLog(p float64) seq.Prob {
+	if p <= 0 {
+		return seq.MinProb
+	}
+	return seq.Prob(-math.Log(p))
+}
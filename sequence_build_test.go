@@ -0,0 +1,122 @@
+package fragbag
+
+import (
+	"math"
+	"testing"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+)
+
+var (
+	buildLine = []structure.Coords{{0, 0, 0}, {3.8, 0, 0}, {7.6, 0, 0}}
+	buildBend = []structure.Coords{{0, 0, 0}, {3.8, 0, 0}, {3.8, 3.8, 0}}
+)
+
+// testChain returns a PDB chain whose first model has a residue for every
+// residue and alpha-carbon atom given, numbered consecutively from 1.
+func testChain(
+	ident byte,
+	residues string,
+	atoms []structure.Coords,
+) *pdb.Chain {
+	entry := &pdb.Entry{IdCode: "test"}
+	chain := &pdb.Chain{Entry: entry, Ident: ident}
+	model := &pdb.Model{Entry: entry, Chain: chain, Num: 1}
+	for i, ca := range atoms {
+		model.Residues = append(model.Residues, &pdb.Residue{
+			Name:        seq.Residue(residues[i]),
+			SequenceNum: i + 1,
+			Atoms:       []pdb.Atom{{Name: "CA", Coords: ca}},
+		})
+	}
+	chain.Models = []*pdb.Model{model}
+	return chain
+}
+
+func testBuilder(t *testing.T) *SequenceBuilder {
+	lib, err := NewStructureAtoms("test",
+		[][]structure.Coords{buildLine, buildBend})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shifted := make([]structure.Coords, len(buildLine))
+	for i, c := range buildLine {
+		shifted[i] = structure.Coords{X: c.X + 10, Y: c.Y - 5, Z: c.Z + 1}
+	}
+	b := NewSequenceBuilder(lib, seq.Alphabet("ACDEG"))
+	for _, chain := range []*pdb.Chain{
+		testChain('A', "ACD", buildLine),
+		testChain('B', "ACE", buildBend),
+		testChain('C', "GCD", shifted),
+	} {
+		if err := b.AddChain(chain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+// assertEmission checks the probability of a residue in emissions.
+func assertEmission(
+	t *testing.T,
+	frag, column int,
+	ep seq.EProbs,
+	r seq.Residue,
+	want float64,
+) {
+	if got := ep.Lookup(r).Ratio(); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("Fragment %d, column %d: expected probability %f for "+
+			"'%c' but got %f.", frag, column, want, r, got)
+	}
+}
+
+func TestSequenceBuilder(t *testing.T) {
+	b := testBuilder(t)
+	if b.windows[0] != 2 || b.windows[1] != 1 {
+		t.Fatalf("Expected window counts [2 1] but got %v.", b.windows)
+	}
+
+	tests := []struct {
+		frag, column int
+		residue      seq.Residue
+		want         float64
+	}{
+		{0, 0, 'A', 0.5},
+		{0, 0, 'G', 0.5},
+		{0, 1, 'C', 1},
+		{0, 2, 'D', 1},
+		{0, 2, 'E', 0},
+		{1, 0, 'A', 1},
+		{1, 2, 'E', 1},
+	}
+
+	plib, err := b.Profile("test", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		prof := plib.Fragment(test.frag).(*seq.Profile)
+		assertEmission(t, test.frag, test.column,
+			prof.Emissions[test.column], test.residue, test.want)
+	}
+
+	hlib, err := b.HMM("test", 0, nil, HMMViterbi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		hmm := hlib.Fragment(test.frag).(*seq.HMM)
+		assertEmission(t, test.frag, test.column,
+			hmm.Nodes[test.column].MatEmit, test.residue, test.want)
+	}
+
+	// Transitions are estimated from the number of windows of each fragment.
+	hmm := hlib.Fragment(0).(*seq.HMM)
+	if got := hmm.Nodes[0].Transitions.MM.Ratio(); math.Abs(got-0.6) > 1e-9 {
+		t.Fatalf("Expected match to match probability 0.6 but got %f.", got)
+	}
+	assertEmission(t, 0, 0, hmm.Null, 'A', 0.2)
+}
@@ -1,20 +1,70 @@
 package fragbag
 
 import (
+	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/TuftsBCB/seq"
 )
 
-var _ = SequenceLibrary(&sequenceHMM{})
+var _ = ProfileLibrary(&sequenceHMM{})
 
 // sequenceHMM represents a Fragbag sequence fragment library.
 // Fragbag fragment libraries are fixed both in the number of fragments and in
 // the size of each fragment.
 type sequenceHMM struct {
+	fingerprintCache
 	Ident     string
 	Fragments []sequenceHMMFrag
 	FragSize  int
+	Scoring   HMMScoring
+
+	// Forward tables for AlignmentProb, so that scoring a query against
+	// every fragment reuses the same memory.
+	tables sync.Pool
+}
+
+// HMMScoring specifies how a query sequence is scored against each fragment
+// in a sequence HMM library. The scoring method is saved with the library,
+// so that a library always scores queries the same way once it is opened.
+type HMMScoring int
+
+const (
+	// HMMViterbi scores a query with the probability of its most likely
+	// path through a fragment (computed by Viterbi).
+	HMMViterbi HMMScoring = iota
+
+	// HMMForward scores a query with the probability of the query summed
+	// over all paths through a fragment (computed by the forward algorithm).
+	HMMForward
+
+	// HMMLogOdds scores a query with the forward probability of the query
+	// relative to the probability of the query under the fragment's null
+	// model.
+	HMMLogOdds
+)
+
+// check returns an error if the scoring method is not one of the methods
+// defined in this package.
+func (scoring HMMScoring) check() error {
+	switch scoring {
+	case HMMViterbi, HMMForward, HMMLogOdds:
+		return nil
+	}
+	return fmt.Errorf("Unrecognized HMM scoring method: %s", scoring)
+}
+
+func (scoring HMMScoring) String() string {
+	switch scoring {
+	case HMMViterbi:
+		return "viterbi"
+	case HMMForward:
+		return "forward"
+	case HMMLogOdds:
+		return "log-odds"
+	}
+	return fmt.Sprintf("unknown (%d)", int(scoring))
 }
 
 // Fragment corresponds to a single sequence fragment in a fragment library.
@@ -34,8 +84,23 @@ func NewSequenceHMM(
 	name string,
 	fragments []*seq.HMM,
 ) (SequenceLibrary, error) {
+	return NewSequenceHMMScoring(name, fragments, HMMViterbi)
+}
+
+// NewSequenceHMMScoring is just like NewSequenceHMM, except the best fragment
+// for any particular sequence is computed with the scoring method given.
+func NewSequenceHMMScoring(
+	name string,
+	fragments []*seq.HMM,
+	scoring HMMScoring,
+) (SequenceLibrary, error) {
+	if err := scoring.check(); err != nil {
+		return nil, err
+	}
+
 	lib := new(sequenceHMM)
 	lib.Ident = name
+	lib.Scoring = scoring
 	for _, frag := range fragments {
 		if err := lib.add(frag); err != nil {
 			return nil, err
@@ -44,6 +109,16 @@ func NewSequenceHMM(
 	return lib, nil
 }
 
+// UnmarshalJSON decodes a library and checks its scoring method, so that a
+// library with an unknown scoring method cannot be opened.
+func (lib *sequenceHMM) UnmarshalJSON(data []byte) error {
+	type plain sequenceHMM
+	if err := json.Unmarshal(data, (*plain)(lib)); err != nil {
+		return err
+	}
+	return lib.Scoring.check()
+}
+
 func (lib *sequenceHMM) SubLibrary() Library {
 	return nil
 }
@@ -86,10 +161,10 @@ func (lib *sequenceHMM) FragmentSize() int {
 }
 
 // String returns a string with the name of the library, the number of
-// fragments in the library and the size of each fragment.
+// fragments in the library, the size of each fragment and the scoring method.
 func (lib *sequenceHMM) String() string {
-	return fmt.Sprintf("%s (%d, %d)",
-		lib.Ident, len(lib.Fragments), lib.FragSize)
+	return fmt.Sprintf("%s (%d, %d, %s)",
+		lib.Ident, len(lib.Fragments), lib.FragSize, lib.Scoring)
 }
 
 func (lib *sequenceHMM) Name() string {
@@ -108,10 +183,17 @@ func (lib *sequenceHMM) BestSequenceFragment(s seq.Sequence) int {
 			s.Len(), lib.FragmentSize()))
 	}
 	var testAlign seq.Prob
-	dynamicTable := seq.AllocTable(lib.FragmentSize(), s.Len())
+	var dynamicTable *seq.DynamicTable
+	var fwdTable *forwardTable
+	if lib.Scoring == HMMViterbi {
+		dynamicTable = seq.AllocTable(lib.FragmentSize(), s.Len())
+	} else {
+		fwdTable = lib.forwardTable()
+		defer lib.tables.Put(fwdTable)
+	}
 	bestAlign, bestFragNum := seq.MinProb, -1
 	for _, frag := range lib.Fragments {
-		testAlign = frag.ViterbiScoreMem(s, dynamicTable)
+		testAlign = lib.score(frag.HMM, s, dynamicTable, fwdTable)
 		if bestAlign.Less(testAlign) {
 			bestAlign, bestFragNum = testAlign, frag.FragNumber
 		}
@@ -120,15 +202,106 @@ func (lib *sequenceHMM) BestSequenceFragment(s seq.Sequence) int {
 }
 
 // AlignmentProb computes the probability of the sequence `s` aligning
-// with the HMM in `frag`. The sequence must have length equivalent
-// to the fragment size.
+// with the HMM in `frag` using the library's scoring method. The sequence
+// must have length equivalent to the fragment size.
 func (lib *sequenceHMM) AlignmentProb(fragi int, s seq.Sequence) seq.Prob {
 	frag := lib.Fragments[fragi]
 	if s.Len() != len(frag.Nodes) {
 		panic(fmt.Sprintf("Sequence length %d != fragment size %d",
 			s.Len(), len(frag.Nodes)))
 	}
-	return frag.ViterbiScore(s)
+	if lib.Scoring == HMMViterbi {
+		return frag.ViterbiScore(s)
+	}
+	table := lib.forwardTable()
+	defer lib.tables.Put(table)
+	return lib.score(frag.HMM, s, nil, table)
+}
+
+// forwardTable returns a forward table for queries with the length of a
+// fragment, reusing a table that is no longer in use if there is one. The
+// table should be returned to lib.tables when done.
+func (lib *sequenceHMM) forwardTable() *forwardTable {
+	if t, ok := lib.tables.Get().(*forwardTable); ok {
+		return t
+	}
+	return allocForwardTable(lib.FragSize, lib.FragSize)
+}
+
+// BestProfileFragment returns the number of the fragment that best
+// corresponds to the query profile given. The number of columns in the
+// profile must be equivalent to the fragment size.
+//
+// Since a query profile has no insertions or deletions relative to a
+// fragment, it is scored along the path through the match states of each
+// fragment (see ProfileProb). So the HMMViterbi and HMMForward scoring
+// methods score profiles in the same way.
+func (lib *sequenceHMM) BestProfileFragment(p *seq.Profile) int {
+	checkProfile(p, lib.FragSize)
+	bestAlign, bestFragNum := seq.MinProb, -1
+	for _, frag := range lib.Fragments {
+		testAlign := lib.profileScore(frag.HMM, p)
+		if bestAlign.Less(testAlign) {
+			bestAlign, bestFragNum = testAlign, frag.FragNumber
+		}
+	}
+	return bestFragNum
+}
+
+// ProfileProb computes the probability of the query profile `p` aligning
+// with the HMM in `frag`. The profile must have a number of columns
+// equivalent to the fragment size.
+//
+// The probability is computed along the path through the match states of
+// the HMM. The probability of each column of the query is the probability
+// that the column and the corresponding match state emit the same residue,
+// summed over all residues. The scoring method of the library only matters
+// in that with the HMMLogOdds scoring method, the probability of each
+// residue in a match state is divided by its probability under the HMM's
+// null model. (There is only one path, so the Viterbi and forward
+// probabilities are the same.)
+func (lib *sequenceHMM) ProfileProb(fragi int, p *seq.Profile) seq.Prob {
+	checkProfile(p, lib.FragSize)
+	return lib.profileScore(lib.Fragments[fragi].HMM, p)
+}
+
+// profileScore computes the probability of the query profile given aligning
+// with the match states of the HMM given. It does not dispatch on the
+// scoring method like score does, since Viterbi and forward are the same
+// along a single path.
+func (lib *sequenceHMM) profileScore(hmm *seq.HMM, p *seq.Profile) seq.Prob {
+	var bg map[seq.Residue]float64
+	if lib.Scoring == HMMLogOdds {
+		bg = nullRatios(hmm.Null, 0, p)
+	}
+	residues := commonResidues(p.Alphabet, hmm.Alphabet)
+	prob := seq.Prob(0.0)
+	for c, node := range hmm.Nodes {
+		prob += columnProb(p.Emissions[c], node.MatEmit, residues, bg)
+		if c < len(hmm.Nodes)-1 {
+			prob += node.Transitions.MM
+		}
+	}
+	return prob
+}
+
+// score dispatches on the library's scoring method. The table corresponding
+// to the scoring method must not be nil.
+func (lib *sequenceHMM) score(
+	hmm *seq.HMM,
+	s seq.Sequence,
+	dynamicTable *seq.DynamicTable,
+	fwdTable *forwardTable,
+) seq.Prob {
+	switch lib.Scoring {
+	case HMMViterbi:
+		return hmm.ViterbiScoreMem(s, dynamicTable)
+	case HMMForward:
+		return fwdTable.forward(hmm, s)
+	case HMMLogOdds:
+		return fwdTable.forward(hmm, s) - nullScore(hmm.Null, s)
+	}
+	panic(fmt.Sprintf("Unrecognized HMM scoring method: %s", lib.Scoring))
 }
 
 func (lib *sequenceHMM) Fragment(fragNum int) interface{} {
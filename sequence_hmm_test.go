@@ -0,0 +1,122 @@
+package fragbag
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/TuftsBCB/seq"
+)
+
+// testEProbs returns emission probabilities over the alphabet given, where
+// probs[i] is the probability of the residue alpha[i].
+func testEProbs(alpha seq.Alphabet, probs ...float64) seq.EProbs {
+	ep := seq.NewEProbs(alpha)
+	for i, r := range alpha {
+		ep.Set(r, negLog(probs[i]))
+	}
+	return ep
+}
+
+// testHMM returns an HMM with three nodes over the alphabet "AB".
+func testHMM() *seq.HMM {
+	alpha := seq.Alphabet("AB")
+	trans := seq.TProbs{
+		MM: negLog(0.6), MI: negLog(0.3), MD: negLog(0.1),
+		IM: negLog(0.7), II: negLog(0.3),
+		DM: negLog(0.5), DD: negLog(0.5),
+	}
+	null := testEProbs(alpha, 0.5, 0.5)
+	matches := []seq.EProbs{
+		testEProbs(alpha, 0.8, 0.2),
+		testEProbs(alpha, 0.4, 0.6),
+		testEProbs(alpha, 0.3, 0.7),
+	}
+	nodes := make([]seq.HMMNode, len(matches))
+	for i := range nodes {
+		nodes[i] = seq.HMMNode{
+			NodeNum:     i,
+			MatEmit:     matches[i],
+			InsEmit:     null,
+			Transitions: trans,
+		}
+	}
+	return seq.NewHMM(nodes, alpha, null)
+}
+
+func TestHMMScoring(t *testing.T) {
+	// The sequence "ABA" can only be emitted by three paths through the
+	// HMM: M0 M1 M2, M0 I0 M1 D2 and M0 D1 M2 I2.
+	s := seq.NewSequenceString("test", "ABA")
+	paths := []float64{
+		0.8 * 0.6 * 0.6 * 0.6 * 0.3,
+		0.8 * 0.3 * 0.5 * 0.7 * 0.4 * 0.1,
+		0.8 * 0.1 * 0.5 * 0.7 * 0.3 * 0.5,
+	}
+	viterbi, forward := 0.0, 0.0
+	for _, p := range paths {
+		viterbi = math.Max(viterbi, p)
+		forward += p
+	}
+	logOdds := forward / (0.5 * 0.5 * 0.5)
+
+	tests := []struct {
+		scoring HMMScoring
+		want    float64
+	}{
+		{HMMForward, forward},
+		{HMMLogOdds, logOdds},
+	}
+	for _, test := range tests {
+		lib, err := NewSequenceHMMScoring("test", []*seq.HMM{testHMM()},
+			test.scoring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Score twice, so that the second score reuses a forward table.
+		for i := 0; i < 2; i++ {
+			got := lib.AlignmentProb(0, s).Ratio()
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Fatalf("Expected %s probability %f but got %f.",
+					test.scoring, test.want, got)
+			}
+		}
+	}
+
+	// The forward probability sums over all paths, so it must be greater
+	// than the probability of the most likely path.
+	if forward <= viterbi {
+		t.Fatalf("Forward probability %f is not greater than Viterbi "+
+			"probability %f.", forward, viterbi)
+	}
+	table := allocForwardTable(3, s.Len())
+	if got := table.forward(testHMM(), s).Ratio(); got <= viterbi {
+		t.Fatalf("Forward probability %f is not greater than Viterbi "+
+			"probability %f.", got, viterbi)
+	}
+}
+
+func TestHMMUnknownScoring(t *testing.T) {
+	lib, err := NewSequenceHMMScoring("test", []*seq.HMM{testHMM()},
+		HMMForward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib.(*sequenceHMM).Scoring = HMMScoring(42)
+
+	savers := map[string]func(io.Writer, Library) error{
+		"json":   Save,
+		"binary": SaveBinary,
+	}
+	for name, save := range savers {
+		buf := new(bytes.Buffer)
+		if err := save(buf, lib); err != nil {
+			t.Fatalf("Could not save %s library: %s", name, err)
+		}
+		if _, err := Open(buf); err == nil {
+			t.Fatalf("Expected an error opening a %s library with an "+
+				"unknown scoring method.", name)
+		}
+	}
+}
@@ -2,19 +2,30 @@ package fragbag
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/TuftsBCB/seq"
 )
 
-var _ = SequenceLibrary(&sequenceProfile{})
+var _ = ProfileLibrary(&sequenceProfile{})
 
 // sequenceProfile represents a Fragbag sequence fragment library.
 // Fragbag fragment libraries are fixed both in the number of fragments and in
 // the size of each fragment.
 type sequenceProfile struct {
+	fingerprintCache
 	Ident     string
 	Fragments []sequenceProfileFrag
 	FragSize  int
+
+	// Null is the background distribution of residues. When it is nil,
+	// queries are scored with the raw emissions of each fragment.
+	Null *seq.EProbs
+
+	// QueryComposition is the weight given to the composition of the query
+	// when computing the background probability of each residue. It is in
+	// the range [0, 1] and is only used when Null is not nil.
+	QueryComposition float64
 }
 
 // Fragment corresponds to a single sequence fragment in a fragment library.
@@ -34,8 +45,41 @@ func NewSequenceProfile(
 	name string,
 	fragments []*seq.Profile,
 ) (SequenceLibrary, error) {
+	return NewSequenceProfileLogOdds(name, fragments, nil, 0)
+}
+
+// NewSequenceProfileLogOdds is just like NewSequenceProfile, except queries
+// are scored as log-odds against the background distribution given. This
+// makes scores comparable across fragments, since fragments that emit common
+// residues with high probability no longer win regardless of the query.
+//
+// If queryComp is greater than zero, the background distribution is corrected
+// for the composition of each query. Namely, the background probability of a
+// residue is computed as `(1 - queryComp) * null + queryComp * query`, where
+// `query` is the frequency of the residue in the query. queryComp must be in
+// the range [0, 1].
+//
+// With a background distribution, BestSequenceFragment and
+// BestProfileFragment return -1 when the query is more likely under the
+// background than under every fragment. Such windows are left out of BOWs
+// (see bow.SequenceBow).
+//
+// If null is nil, then this is equivalent to NewSequenceProfile.
+func NewSequenceProfileLogOdds(
+	name string,
+	fragments []*seq.Profile,
+	null *seq.EProbs,
+	queryComp float64,
+) (SequenceLibrary, error) {
+	if queryComp < 0 || queryComp > 1 {
+		return nil, fmt.Errorf("The query composition weight must be in "+
+			"the range [0, 1], but %f was given.", queryComp)
+	}
+
 	lib := new(sequenceProfile)
 	lib.Ident = name
+	lib.Null = null
+	lib.QueryComposition = queryComp
 	for _, frag := range fragments {
 		if err := lib.add(frag); err != nil {
 			return nil, err
@@ -100,7 +144,9 @@ func (lib *sequenceProfile) Name() string {
 // The length of `sequence` must be equivalent to the fragment size.
 //
 // If no "good" fragments can be found, then `-1` is returned. This
-// behavior will almost certainly change in the future.
+// behavior will almost certainly change in the future. When the library has
+// a background distribution, a fragment is only "good" if the query is more
+// likely to have been emitted by the fragment than by the background.
 func (lib *sequenceProfile) BestSequenceFragment(s seq.Sequence) int {
 	// Since fragments are guaranteed not to have gaps by construction,
 	// we can do a straight-forward summation of the negative log-odds
@@ -108,11 +154,19 @@ func (lib *sequenceProfile) BestSequenceFragment(s seq.Sequence) int {
 	var testAlign seq.Prob
 	bestAlign, bestFragNum := seq.MinProb, -1
 	for i := range lib.Fragments {
-		testAlign = lib.AlignmentProb(i, s)
+		testAlign = lib.emissionProb(i, s)
 		if bestAlign.Less(testAlign) {
 			bestAlign, bestFragNum = testAlign, i
 		}
 	}
+
+	// The background is the same for every fragment, so it only needs to
+	// be computed once for the best fragment.
+	if lib.Null != nil && bestFragNum > -1 {
+		if bestAlign-lib.background(s) >= 0 {
+			return -1
+		}
+	}
 	return bestFragNum
 }
 
@@ -127,7 +181,90 @@ func (lib *sequenceProfile) Fragment(fragNum int) interface{} {
 // AlignmentProb computes the probability of the sequence `s` aligning
 // with the profile in `frag`. The sequence must have length equivalent
 // to the fragment size.
+//
+// If the library has a background distribution, then the probability is
+// the log-odds of the alignment against the background.
 func (lib *sequenceProfile) AlignmentProb(fragi int, s seq.Sequence) seq.Prob {
+	prob := lib.emissionProb(fragi, s)
+	if lib.Null != nil {
+		prob -= lib.background(s)
+	}
+	return prob
+}
+
+// BestProfileFragment returns the number of the fragment that best
+// corresponds to the query profile given. The number of columns in the
+// profile must be equivalent to the fragment size.
+//
+// Each column of the query is compared with the corresponding column of each
+// fragment (see ProfileProb). Just like BestSequenceFragment, a fragment is
+// only "good" when the library has a background distribution if the query is
+// more likely to match the fragment than the background.
+func (lib *sequenceProfile) BestProfileFragment(p *seq.Profile) int {
+	checkProfile(p, lib.FragSize)
+	bg := lib.profileBackground(p)
+	bestAlign, bestFragNum := seq.MinProb, -1
+	for i := range lib.Fragments {
+		testAlign := lib.profileProb(i, p, bg)
+		if bestAlign.Less(testAlign) {
+			bestAlign, bestFragNum = testAlign, i
+		}
+	}
+	if bg != nil && bestAlign >= 0 {
+		return -1
+	}
+	return bestFragNum
+}
+
+// ProfileProb computes the probability of the query profile `p` aligning
+// with the profile in `frag`. The profile must have a number of columns
+// equivalent to the fragment size.
+//
+// The probability of each pair of columns is the probability that both
+// columns emit the same residue, summed over all residues. If the library has
+// a background distribution, then the probability of each residue in the
+// fragment is divided by its background probability. (This is the same as
+// AlignmentProb when every column of the query has a single residue.)
+func (lib *sequenceProfile) ProfileProb(fragi int, p *seq.Profile) seq.Prob {
+	checkProfile(p, lib.FragSize)
+	return lib.profileProb(fragi, p, lib.profileBackground(p))
+}
+
+// profileProb computes ProfileProb with the background probabilities given,
+// which may be nil.
+func (lib *sequenceProfile) profileProb(
+	fragi int,
+	p *seq.Profile,
+	bg map[seq.Residue]float64,
+) seq.Prob {
+	frag := lib.Fragments[fragi]
+	residues := commonResidues(p.Alphabet, frag.Alphabet)
+	prob := seq.Prob(0.0)
+	for c := range p.Emissions {
+		prob += columnProb(p.Emissions[c], frag.Emissions[c], residues, bg)
+	}
+	return prob
+}
+
+// profileBackground returns the background probability of every residue,
+// corrected for the composition of the query profile given if the library
+// has a query composition weight. It returns nil if the library has no
+// background distribution.
+func (lib *sequenceProfile) profileBackground(
+	p *seq.Profile,
+) map[seq.Residue]float64 {
+	if lib.Null == nil {
+		return nil
+	}
+	return nullRatios(*lib.Null, lib.QueryComposition, p)
+}
+
+// emissionProb computes the probability of the sequence `s` being emitted
+// by the profile in `frag` without regard to the background distribution.
+func (lib *sequenceProfile) emissionProb(
+	fragi int,
+	s seq.Sequence,
+) seq.Prob {
 	frag := lib.Fragments[fragi]
 	if s.Len() != frag.Len() {
 		panic(fmt.Sprintf("Sequence length %d != fragment size %d",
@@ -139,3 +276,27 @@ func (lib *sequenceProfile) AlignmentProb(fragi int, s seq.Sequence) seq.Prob {
 	}
 	return prob
 }
+
+// background returns the probability of the sequence given under the
+// library's background distribution, corrected for the composition of the
+// sequence if the library has a query composition weight.
+func (lib *sequenceProfile) background(s seq.Sequence) seq.Prob {
+	prob := seq.Prob(0.0)
+	if lib.QueryComposition == 0 {
+		for _, r := range s.Residues {
+			prob += lib.Null.Lookup(r)
+		}
+		return prob
+	}
+
+	counts := make(map[seq.Residue]int, s.Len())
+	for _, r := range s.Residues {
+		counts[r]++
+	}
+	w, n := lib.QueryComposition, float64(s.Len())
+	for _, r := range s.Residues {
+		ratio := (1-w)*lib.Null.Lookup(r).Ratio() + w*float64(counts[r])/n
+		prob += seq.Prob(-math.Log(ratio))
+	}
+	return prob
+}
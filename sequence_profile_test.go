@@ -0,0 +1,125 @@
+package fragbag
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/TuftsBCB/seq"
+)
+
+// testProfileLibrary returns a library of profiles over the alphabet "AB"
+// with the background and query composition weight given. Every column of
+// fragment i emits 'A' with probability probA[i].
+func testProfileLibrary(
+	t *testing.T,
+	null *seq.EProbs,
+	queryComp float64,
+	probA ...float64,
+) SequenceLibrary {
+	alpha := seq.Alphabet("AB")
+	frags := make([]*seq.Profile, len(probA))
+	for i, pa := range probA {
+		frags[i] = seq.NewProfileAlphabet(2, alpha)
+		for c := range frags[i].Emissions {
+			frags[i].Emissions[c] = testEProbs(alpha, pa, 1-pa)
+		}
+	}
+	lib, err := NewSequenceProfileLogOdds("test", frags, null, queryComp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func TestSequenceProfileLogOdds(t *testing.T) {
+	null := testEProbs(seq.Alphabet("AB"), 0.5, 0.5)
+	tests := []struct {
+		null      *seq.EProbs
+		queryComp float64
+		probA     []float64
+		query     string
+		frag      int
+		prob      float64 // of the query aligning with fragment 0
+	}{
+		// Without a background, raw emissions are compared.
+		{nil, 0, []float64{0.9, 0.6}, "AA", 0, 0.81},
+		{nil, 0, []float64{0.9, 0.6}, "BB", 1, 0.01},
+		{nil, 0, []float64{0.6}, "BB", 0, 0.16},
+
+		// With a background, the query must be more likely to be emitted
+		// by the best fragment than by the background. Otherwise, no
+		// fragment is returned.
+		{&null, 0, []float64{0.9, 0.6}, "AA", 0, 0.81 / 0.25},
+		{&null, 0, []float64{0.9, 0.6}, "BB", -1, 0.01 / 0.25},
+		{&null, 0, []float64{0.6}, "AA", 0, 0.36 / 0.25},
+
+		// The query composition correction makes the background of 'A'
+		// 0.5*0.5 + 0.5*1 = 0.75 for "AA", so the fragment no longer beats
+		// the background. For "AB", the query has the same composition as
+		// the background.
+		{&null, 0.5, []float64{0.6}, "AA", -1, 0.36 / (0.75 * 0.75)},
+		{&null, 0.5, []float64{0.6}, "AB", -1, 0.24 / 0.25},
+		{&null, 0.5, []float64{0.9}, "AA", 0, 0.81 / (0.75 * 0.75)},
+	}
+	for _, test := range tests {
+		lib := testProfileLibrary(t, test.null, test.queryComp, test.probA...)
+		s := seq.NewSequenceString("query", test.query)
+		if got := lib.BestSequenceFragment(s); got != test.frag {
+			t.Fatalf("%s (null: %v, query composition: %f): Expected "+
+				"fragment %d but got %d.", test.query, test.null != nil,
+				test.queryComp, test.frag, got)
+		}
+		got := lib.AlignmentProb(0, s).Ratio()
+		if math.Abs(got-test.prob) > 1e-9 {
+			t.Fatalf("%s (null: %v, query composition: %f): Expected "+
+				"probability %f but got %f.", test.query, test.null != nil,
+				test.queryComp, test.prob, got)
+		}
+	}
+}
+
+func TestSequenceProfileQueryCompositionRange(t *testing.T) {
+	null := testEProbs(seq.Alphabet("AB"), 0.5, 0.5)
+	for _, w := range []float64{-0.1, 1.5} {
+		_, err := NewSequenceProfileLogOdds("test", nil, &null, w)
+		if err == nil {
+			t.Fatalf("Expected an error for query composition weight %f.",
+				w)
+		}
+	}
+}
+
+func TestSequenceProfileBinaryNull(t *testing.T) {
+	// The background covers more residues than the fragments emit.
+	alpha := seq.Alphabet("ABC")
+	null := testEProbs(alpha, 0.5, 0.3, 0.2)
+	lib := testProfileLibrary(t, &null, 0.25, 0.9, 0.6)
+
+	buf := new(bytes.Buffer)
+	if err := SaveBinary(buf, lib); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Open(buf)
+	if err != nil {
+		t.Fatalf("Could not open library: %s", err)
+	}
+	got := read.(*sequenceProfile)
+	if got.Null == nil {
+		t.Fatal("Expected a background distribution but got none.")
+	}
+	if string(got.Null.Alphabet) != string(alpha) {
+		t.Fatalf("Expected background alphabet '%s' but got '%s'.",
+			alpha, got.Null.Alphabet)
+	}
+	for _, r := range alpha {
+		if got.Null.Lookup(r) != null.Lookup(r) {
+			t.Fatalf("Expected background probability %f for '%c' but "+
+				"got %f.", null.Lookup(r), r, got.Null.Lookup(r))
+		}
+	}
+	if got.QueryComposition != 0.25 {
+		t.Fatalf("Expected query composition weight %f but got %f.",
+			0.25, got.QueryComposition)
+	}
+}
@@ -13,6 +13,7 @@ var _ = StructureLibrary(&structureAtoms{})
 // Fragbag fragment libraries are fixed both in the number of fragments and in
 // the size of each fragment.
 type structureAtoms struct {
+	fingerprintCache
 	Ident     string
 	Fragments []structureAtomsFrag
 	FragSize  int
@@ -10,7 +10,7 @@ import (
 var (
 	_ = WeightedLibrary(&weightedTfIdf{})
 	_ = StructureLibrary(&weightedTfIdf{})
-	_ = SequenceLibrary(&weightedTfIdf{})
+	_ = ProfileLibrary(&weightedTfIdf{})
 )
 
 // weightedTfIdf wraps any fragment library so that all BOWs are weighted
@@ -20,6 +20,7 @@ var (
 // interfaces, but only one will work, depending upon the underlying value
 // of the wrapped library.
 type weightedTfIdf struct {
+	fingerprintCache
 	Library
 	FragIDFs []float32
 }
@@ -39,7 +40,7 @@ func NewWeightedTfIdf(lib Library, idfs []float32) (WeightedLibrary, error) {
 			"library has %d fragments but %d weights were given.",
 			lib.Size(), len(idfs))
 	}
-	return &weightedTfIdf{lib, idfs}, nil
+	return &weightedTfIdf{Library: lib, FragIDFs: idfs}, nil
 }
 
 func (lib *weightedTfIdf) SubLibrary() Library {
@@ -66,7 +67,7 @@ func makeWeightedTfIdf(subTags ...string) (Library, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &weightedTfIdf{empty, nil}, nil
+	return &weightedTfIdf{Library: empty}, nil
 }
 
 // BestStructureFragment calls the corresponding method on the underlying
@@ -91,3 +92,15 @@ func (lib *weightedTfIdf) BestSequenceFragment(s seq.Sequence) int {
 func (lib *weightedTfIdf) AlignmentProb(fragNum int, s seq.Sequence) seq.Prob {
 	return lib.Library.(SequenceLibrary).AlignmentProb(fragNum, s)
 }
+
+// BestProfileFragment calls the corresponding function on the underlying
+// fragment library. (See the BestProfileFragment function.)
+func (lib *weightedTfIdf) BestProfileFragment(p *seq.Profile) int {
+	return BestProfileFragment(lib.Library.(SequenceLibrary), p)
+}
+
+// ProfileProb calls the corresponding function on the underlying fragment
+// library. (See the ProfileProb function.)
+func (lib *weightedTfIdf) ProfileProb(fragNum int, p *seq.Profile) seq.Prob {
+	return ProfileProb(lib.Library.(SequenceLibrary), fragNum, p)
+}